@@ -35,6 +35,12 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 			Usage:   "load state from `FILE`",
 			Value:   ".strongbox_state.yml",
 		},
+		&cli.StringFlag{
+			Name:    "target",
+			Aliases: []string{"t"},
+			EnvVars: []string{"STRONGBOX_TARGET"},
+			Usage:   "name of the `TARGET` (vault KV mount and transit key) to use, plan and apply consider all of them by default",
+		},
 		&cli.StringFlag{
 			Name:    "vault-addr",
 			EnvVars: []string{"VAULT_ADDR"},
@@ -55,6 +61,24 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 			EnvVars: []string{"VAULT_SECRET_ID"},
 			Usage:   "vault secret id",
 		},
+		&cli.IntFlag{
+			Name:    "transit-batch-size",
+			EnvVars: []string{"STRONGBOX_TRANSIT_BATCH_SIZE"},
+			Usage:   "maximum amount of values sent within a single transit encrypt/decrypt request",
+			Value:   100,
+		},
+		&cli.IntFlag{
+			Name:    "parallelism",
+			EnvVars: []string{"STRONGBOX_PARALLELISM"},
+			Usage:   "maximum amount of concurrent requests made onto the vault KV",
+			Value:   10,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			EnvVars: []string{"STRONGBOX_OUTPUT"},
+			Usage:   "output format (table,json,yaml)",
+			Value:   "table",
+		},
 		&cli.StringFlag{
 			Name:    "log-level",
 			EnvVars: []string{"STRONGBOX_LOG_LEVEL"},
@@ -104,6 +128,30 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 					ArgsUsage: "<vault_transit_key_name>",
 					Action:    cmd.ExecWrapper(cmd.TransitDelete),
 				},
+				{
+					Name:      "rotate",
+					Usage:     "create a new version of a transit key, the configured one by default",
+					ArgsUsage: "[<vault_transit_key_name>]",
+					Action:    cmd.ExecWrapper(cmd.TransitRotate),
+				},
+				{
+					Name:      "assign",
+					Usage:     "use a specific transit key for the secrets (or secret keys) matching a pattern",
+					ArgsUsage: "<secret_pattern>[:<key_pattern>] <vault_transit_key_name>",
+					Action:    cmd.ExecWrapper(cmd.TransitAssign),
+				},
+				{
+					Name:      "unassign",
+					Usage:     "remove the transit key assigned to a pattern",
+					ArgsUsage: "<secret_pattern>[:<key_pattern>]",
+					Action:    cmd.ExecWrapper(cmd.TransitUnassign),
+				},
+				{
+					Name:      "assignments",
+					Usage:     "list the transit keys assigned to some secrets or secret keys",
+					ArgsUsage: " ",
+					Action:    cmd.ExecWrapper(cmd.TransitAssignments),
+				},
 			},
 		},
 		{
@@ -113,7 +161,7 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 				{
 					Name:      "write",
 					Usage:     "write a secret",
-					ArgsUsage: "-s <secret> -k <key> [-v <value> or -r <string_length> or -V]",
+					ArgsUsage: "-s <secret> -k <key> [-v <value> or -r <string_length> or -V] [-j] [-d <description>] [--owner <owner>]",
 					Flags: cli.FlagsByName{
 						&cli.StringFlag{
 							Name:    "secret",
@@ -140,6 +188,20 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 							Aliases: []string{"r"},
 							Usage:   "automatically generates a string of this length",
 						},
+						&cli.BoolFlag{
+							Name:    "json",
+							Aliases: []string{"j"},
+							Usage:   "store the value as JSON (number, boolean, list or object) instead of a string",
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "description of the key, kept from the previous value if not defined",
+						},
+						&cli.StringFlag{
+							Name:  "owner",
+							Usage: "owner of the key, kept from the previous value if not defined",
+						},
 					},
 					Action: cmd.ExecWrapper(cmd.SecretWrite),
 				},
@@ -182,14 +244,104 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 				{
 					Name:      "list",
 					Usage:     "list all managed secrets",
-					ArgsUsage: " ",
-					Action:    cmd.ExecWrapper(cmd.SecretList),
+					ArgsUsage: "[--metadata] [--selector <selector>] [<secret>]",
+					Flags: cli.FlagsByName{
+						&cli.BoolFlag{
+							Name:    "metadata",
+							Aliases: []string{"m"},
+							Usage:   "display the metadata of the keys instead of their ciphered values",
+						},
+						&cli.StringFlag{
+							Name:    "selector",
+							Aliases: []string{"l"},
+							Usage:   "only consider the secrets whose labels match this `SELECTOR` (eg: team=payments,env!=dev)",
+						},
+					},
+					Action: cmd.ExecWrapper(cmd.SecretList),
 				},
 				{
 					Name:      "rotate-from",
 					Usage:     "rotate local secrets encryption from an old transit key",
-					ArgsUsage: "<old_vault_transit_key>",
-					Action:    cmd.ExecWrapper(cmd.SecretRotateFrom),
+					ArgsUsage: "[--selector <selector>] [--dry-run] <old_vault_transit_key>",
+					Flags: cli.FlagsByName{
+						&cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "only report how many values would be rotated",
+						},
+						&cli.StringFlag{
+							Name:    "selector",
+							Aliases: []string{"l"},
+							Usage:   "only consider the secrets whose labels match this `SELECTOR` (eg: team=payments,env!=dev)",
+						},
+					},
+					Action: cmd.ExecWrapper(cmd.SecretRotateFrom),
+				},
+				{
+					Name:      "rewrap",
+					Usage:     "rewrap local secrets using the latest versions of their transit keys, without deciphering them",
+					ArgsUsage: "[--min-decryption-version]",
+					Flags: cli.FlagsByName{
+						&cli.BoolFlag{
+							Name:  "min-decryption-version",
+							Usage: "then prevent the previous versions of the transit keys from being used to decipher values",
+						},
+					},
+					Action: cmd.ExecWrapper(cmd.SecretRewrap),
+				},
+				{
+					Name:      "label",
+					Usage:     "add (key=value) or remove (key-) labels of a secret",
+					ArgsUsage: "-s <secret> <key>=<value>|<key>- ...",
+					Flags: cli.FlagsByName{
+						&cli.StringFlag{
+							Name:    "secret",
+							Aliases: []string{"s"},
+							Usage:   "secret name",
+						},
+					},
+					Action: cmd.ExecWrapper(cmd.SecretLabel),
+				},
+			},
+		},
+		{
+			Name:  "target",
+			Usage: "manage the targets (vault KV mounts and transit keys) of the statefile",
+			Subcommands: cli.CommandsByName{
+				{
+					Name:      "list",
+					Usage:     "list the targets defined in the statefile",
+					ArgsUsage: " ",
+					Action:    cmd.ExecWrapper(cmd.TargetList),
+				},
+				{
+					Name:      "add",
+					Usage:     "add a new target to the statefile",
+					ArgsUsage: "<name>",
+					Action:    cmd.ExecWrapper(cmd.TargetAdd),
+				},
+				{
+					Name:      "remove",
+					Usage:     "remove a target and all its secrets from the statefile",
+					ArgsUsage: "<name>",
+					Action:    cmd.ExecWrapper(cmd.TargetRemove),
+				},
+			},
+		},
+		{
+			Name:  "state",
+			Usage: "perform actions on the state file",
+			Subcommands: cli.CommandsByName{
+				{
+					Name:      "migrate",
+					Usage:     "upgrade the state file to the latest schema version",
+					ArgsUsage: " ",
+					Action:    cmd.LocalExecWrapper(cmd.StateMigrate),
+				},
+				{
+					Name:      "convert",
+					Usage:     "write the state at another location using a given layout (file,directory)",
+					ArgsUsage: "<layout> <destination>",
+					Action:    cmd.ExecWrapper(cmd.StateConvert),
 				},
 			},
 		},
@@ -221,13 +373,118 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 					ArgsUsage: "<version>",
 					Action:    cmd.ExecWrapper(cmd.KVSetVersion),
 				},
+				{
+					Name:      "get-delete-mode",
+					Usage:     "display how secrets get deleted from vault KV v2 by apply",
+					ArgsUsage: " ",
+					Action:    cmd.ExecWrapper(cmd.KVGetDeleteMode),
+				},
+				{
+					Name:      "set-delete-mode",
+					Usage:     "update how secrets get deleted from vault KV v2 by apply (soft,destroy,metadata)",
+					ArgsUsage: "<mode>",
+					Action:    cmd.ExecWrapper(cmd.KVSetDeleteMode),
+				},
+				{
+					Name:      "get-sync-labels",
+					Usage:     "display whether the labels of the secrets get written into their vault KV v2 custom metadata by apply",
+					ArgsUsage: " ",
+					Action:    cmd.ExecWrapper(cmd.KVGetSyncLabels),
+				},
+				{
+					Name:      "set-sync-labels",
+					Usage:     "update whether the labels of the secrets get written into their vault KV v2 custom metadata by apply",
+					ArgsUsage: "<true|false>",
+					Action:    cmd.ExecWrapper(cmd.KVSetSyncLabels),
+				},
+				{
+					Name:      "get-ownership",
+					Usage:     "display which remote secrets are managed by strongbox",
+					ArgsUsage: " ",
+					Action:    cmd.ExecWrapper(cmd.KVGetOwnership),
+				},
+				{
+					Name:      "set-ownership",
+					Usage:     "update which remote secrets are managed by strongbox (all,marked)",
+					ArgsUsage: "<ownership>",
+					Action:    cmd.ExecWrapper(cmd.KVSetOwnership),
+				},
+				{
+					Name:      "ignore",
+					Usage:     "never delete remote secrets matching this pattern",
+					ArgsUsage: "<pattern>",
+					Action:    cmd.ExecWrapper(cmd.KVIgnore),
+				},
+				{
+					Name:      "unignore",
+					Usage:     "remove a pattern from the list of ignored remote secrets",
+					ArgsUsage: "<pattern>",
+					Action:    cmd.ExecWrapper(cmd.KVUnignore),
+				},
+				{
+					Name:      "merge",
+					Usage:     "only manage the keys defined in the state file for the secrets matching this pattern",
+					ArgsUsage: "<pattern>",
+					Action:    cmd.ExecWrapper(cmd.KVMerge),
+				},
+				{
+					Name:      "unmerge",
+					Usage:     "remove a pattern from the list of merged secrets",
+					ArgsUsage: "<pattern>",
+					Action:    cmd.ExecWrapper(cmd.KVUnmerge),
+				},
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "import existing secrets from vault into the state file",
+			ArgsUsage: "[--all] [--overwrite] [<secret>...]",
+			Flags: cli.FlagsByName{
+				&cli.BoolFlag{
+					Name:    "all",
+					Aliases: []string{"a"},
+					Usage:   "import all the secrets stored under the configured KV path",
+				},
+				&cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "overwrite secrets which already exist in the state file instead of skipping them",
+				},
+			},
+			Action: cmd.ExecWrapper(cmd.Import),
+		},
+		{
+			Name:      "pull",
+			Usage:     "update the state file with the values currently stored in vault",
+			ArgsUsage: "[--dry-run] [--delete] [--selector <selector>]",
+			Flags: cli.FlagsByName{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only display the changes which would be made to the state file",
+				},
+				&cli.BoolFlag{
+					Name:  "delete",
+					Usage: "remove secrets and keys from the state file when they do not exist in vault anymore",
+				},
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"l"},
+					Usage:   "only consider the secrets whose labels match this `SELECTOR` (eg: team=payments,env!=dev)",
+				},
 			},
+			Action: cmd.ExecWrapper(cmd.Pull),
 		},
 		{
 			Name:      "init",
 			Usage:     "Create a empty state file at configured location",
-			ArgsUsage: " ",
-			Action:    cmd.ExecWrapper(cmd.Init),
+			ArgsUsage: "[--layout <layout>]",
+			Flags: cli.FlagsByName{
+				&cli.StringFlag{
+					Name:  "layout",
+					Value: "file",
+					Usage: "store the state into a single file or into a directory, with one file per secret (file,directory)",
+				},
+			},
+			Action: cmd.ExecWrapper(cmd.Init),
 		},
 		{
 			Name:      "status",
@@ -238,14 +495,47 @@ func NewApp(version string, start time.Time) (app *cli.App) {
 		{
 			Name:      "plan",
 			Usage:     "compare local version with vault cluster",
-			ArgsUsage: " ",
-			Action:    cmd.ExecWrapper(cmd.Plan),
+			ArgsUsage: "[-out <planfile>] [--selector <selector>]",
+			Flags: cli.FlagsByName{
+				&cli.StringFlag{
+					Name:    "out",
+					Aliases: []string{"o"},
+					Usage:   "save the plan to `FILE`, it can then be applied using 'strongbox apply FILE'",
+				},
+				&cli.StringFlag{
+					Name:  "delete-mode",
+					Usage: "how to delete secrets from vault KV v2 (soft,destroy,metadata), overrides the one configured in the state file",
+				},
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"l"},
+					Usage:   "only consider the secrets whose labels match this `SELECTOR` (eg: team=payments,env!=dev)",
+				},
+			},
+			Action: cmd.ExecWrapper(cmd.Plan),
 		},
 		{
 			Name:      "apply",
 			Usage:     "synchronize vault managed secrets",
-			ArgsUsage: " ",
-			Action:    cmd.ExecWrapper(cmd.Apply),
+			ArgsUsage: "[--delete-mode <mode>] [--selector <selector>] [<planfile>]",
+			Flags: cli.FlagsByName{
+				&cli.StringFlag{
+					Name:  "delete-mode",
+					Usage: "how to delete secrets from vault KV v2 (soft,destroy,metadata), overrides the one configured in the state file",
+				},
+				&cli.StringFlag{
+					Name:    "selector",
+					Aliases: []string{"l"},
+					Usage:   "only consider the secrets whose labels match this `SELECTOR` (eg: team=payments,env!=dev)",
+				},
+			},
+			Action: cmd.ExecWrapper(cmd.Apply),
+		},
+		{
+			Name:      "merge-driver",
+			Usage:     "three-way merge state files, to be used as a git merge driver ('strongbox merge-driver %O %A %B %P')",
+			ArgsUsage: "<ancestor> <current> <other> [<pathname>]",
+			Action:    cmd.LocalExecWrapper(cmd.MergeDriver),
 		},
 	}
 
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+
+	s5 "github.com/mvisonneau/s5/cipher"
+)
+
+// Transit operations which can be run in batches
+const (
+	transitEncrypt = "encrypt"
+	transitDecrypt = "decrypt"
+	transitRewrap  = "rewrap"
+)
+
+// defaultTransitBatchSize : Amount of values sent to Vault within a single transit request
+const defaultTransitBatchSize = 100
+
+var transitBatchSize = defaultTransitBatchSize
+
+// setTransitBatchSize : Configures the amount of values sent within a transit request
+func setTransitBatchSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("transit batch size must be greater than 0, got %d", size)
+	}
+	transitBatchSize = size
+	return nil
+}
+
+// transitItem : Value of a secret key processed by a batch of transit operations. The
+// inputs and outputs are s5 values, apart from the plaintexts
+type transitItem struct {
+	TransitKey string
+	Target     string
+	Secret     string
+	Key        string
+	Input      string
+	Output     string
+	Err        error
+}
+
+// transitPartialFailureCode : Status code returned by Vault when only some of the items of
+// a batch have failed, rather than refusing the whole batch with a 400
+const transitPartialFailureCode = http.StatusMultiStatus
+
+// transitBatch : Runs a transit operation onto the items, in batches of values using the
+// same TransitKey. The outputs, or the errors, are set onto each of the items
+func (v *Vault) transitBatch(operation string, items []*transitItem) {
+	byKey := make(map[string][]*transitItem)
+	for _, item := range items {
+		byKey[item.TransitKey] = append(byKey[item.TransitKey], item)
+	}
+
+	for _, transitKey := range sortedKeys(byKey) {
+		for _, batch := range transitBatches(byKey[transitKey]) {
+			v.transitRequest(operation, transitKey, batch, true)
+		}
+	}
+}
+
+// transitBatches : Splits items into batches of the configured size
+func transitBatches(items []*transitItem) (batches [][]*transitItem) {
+	for start := 0; start < len(items); start += transitBatchSize {
+		end := start + transitBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		batches = append(batches, items[start:end])
+	}
+	return
+}
+
+// transitRequest : Runs a transit operation onto a batch of items. Vault reports the
+// errors of each item in the batch_results, even when all of them have failed. Servers
+// which reject the partial_failure_response_code parameter are sent the batch again
+// without it, and its items one by one if they refuse it as a whole
+func (v *Vault) transitRequest(operation, transitKey string, items []*transitItem, partialFailure bool) {
+	var inputs []interface{}
+	var sent []*transitItem
+	for _, item := range items {
+		input, err := transitInput(operation, item.Input)
+		if err != nil {
+			item.Err = err
+			continue
+		}
+		inputs = append(inputs, input)
+		sent = append(sent, item)
+	}
+
+	if len(sent) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{"batch_input": inputs}
+	if partialFailure {
+		data["partial_failure_response_code"] = transitPartialFailureCode
+	}
+
+	results, err := v.transitWrite("transit/"+operation+"/"+transitKey, data)
+	var re *api.ResponseError
+	switch {
+	case err != nil && errors.As(err, &re) && operation != transitEncrypt && transitKeyInaccessible(re):
+		setTransitError(sent, &inaccessibleError{transitKey: transitKey, err: err})
+		return
+	case err != nil && results == nil && errors.As(err, &re) && re.StatusCode == http.StatusBadRequest && partialFailure:
+		v.transitRequest(operation, transitKey, sent, false)
+		return
+	case err != nil && results == nil && errors.As(err, &re) && re.StatusCode == http.StatusBadRequest && len(sent) > 1:
+		for _, item := range sent {
+			v.transitRequest(operation, transitKey, []*transitItem{item}, false)
+		}
+		return
+	case err != nil && results == nil:
+		setTransitError(sent, fmt.Errorf("Vault error: %v", err))
+		return
+	case len(results) != len(sent):
+		setTransitError(sent, fmt.Errorf("unable to parse batch_results from Vault API response"))
+		return
+	}
+
+	for i, item := range sent {
+		result, _ := results[i].(map[string]interface{})
+		if msg, _ := result["error"].(string); msg != "" {
+			item.Err = fmt.Errorf("Vault error: %v", msg)
+			continue
+		}
+		item.Output, item.Err = transitOutput(operation, result)
+	}
+}
+
+// transitWrite : Sends a batch of transit operations to Vault and returns its batch_results,
+// which are also parsed from the body of the responses refusing the whole batch
+func (v *Vault) transitWrite(path string, data map[string]interface{}) ([]interface{}, error) {
+	r := v.Client.NewRequest(http.MethodPut, "/v1/"+path)
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := v.Client.RawRequestWithContext(context.Background(), r)
+	if resp == nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	secret, parseErr := api.ParseSecret(resp.Body)
+	if secret == nil || secret.Data["batch_results"] == nil {
+		if err == nil {
+			err = fmt.Errorf("unable to parse batch_results from Vault API response: %v", parseErr)
+		}
+		return nil, err
+	}
+
+	results, _ := secret.Data["batch_results"].([]interface{})
+	return results, err
+}
+
+// transitKeyInaccessible : Returns true if Vault refused a transit request because the
+// token is not allowed to use the transit key or because the key does not exist. Any
+// other refusal, like an invalid ciphertext, is an actual error
+func transitKeyInaccessible(re *api.ResponseError) bool {
+	if re.StatusCode == http.StatusForbidden || re.StatusCode == http.StatusNotFound {
+		return true
+	}
+
+	if re.StatusCode == http.StatusBadRequest {
+		for _, msg := range re.Errors {
+			if strings.Contains(msg, "encryption key not found") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transitInput : Returns the batch_input entry of a value
+func transitInput(operation, value string) (map[string]interface{}, error) {
+	if operation == transitEncrypt {
+		return map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString([]byte(value))}, nil
+	}
+
+	parsedInput, err := s5.ParseInput(value)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"ciphertext": vaultCiphertext(parsedInput)}, nil
+}
+
+// transitOutput : Returns the value held by an entry of the batch_results
+func transitOutput(operation string, result map[string]interface{}) (string, error) {
+	if operation == transitDecrypt {
+		plaintext, ok := result["plaintext"].(string)
+		if !ok {
+			return "", fmt.Errorf("unable to parse plaintext from Vault API response")
+		}
+
+		output, err := base64.StdEncoding.DecodeString(plaintext)
+		return string(output), err
+	}
+
+	ciphertext, ok := result["ciphertext"].(string)
+	if !ok {
+		return "", fmt.Errorf("unable to parse ciphertext from Vault API response")
+	}
+	return s5.GenerateOutput(s5Ciphertext(ciphertext)), nil
+}
+
+func setTransitError(items []*transitItem, err error) {
+	for _, item := range items {
+		item.Err = err
+	}
+}
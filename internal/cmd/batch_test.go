@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTransitBatchSize(t *testing.T) {
+	defer func() { transitBatchSize = defaultTransitBatchSize }()
+
+	assert.NoError(t, setTransitBatchSize(2))
+	assert.Equal(t, 2, transitBatchSize)
+	assert.Error(t, setTransitBatchSize(0))
+	assert.Equal(t, 2, transitBatchSize)
+}
+
+func TestTransitBatches(t *testing.T) {
+	defer func() { transitBatchSize = defaultTransitBatchSize }()
+	require.NoError(t, setTransitBatchSize(2))
+
+	items := []*transitItem{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	batches := transitBatches(items)
+	require.Len(t, batches, 2)
+	assert.Equal(t, items[:2], batches[0])
+	assert.Equal(t, items[2:], batches[1])
+	assert.Empty(t, transitBatches(nil))
+}
+
+func TestTransitInputOutput(t *testing.T) {
+	input, err := transitInput(transitEncrypt, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"plaintext": "Zm9v"}, input)
+
+	input, err = transitInput(transitDecrypt, "{{s5:v2=Zm9v}}")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ciphertext": "vault:v2:Zm9v"}, input)
+
+	_, err = transitInput(transitDecrypt, "foo")
+	assert.Error(t, err)
+
+	output, err := transitOutput(transitDecrypt, map[string]interface{}{"plaintext": "Zm9v"})
+	require.NoError(t, err)
+	assert.Equal(t, "foo", output)
+
+	output, err = transitOutput(transitRewrap, map[string]interface{}{"ciphertext": "vault:v3:Zm9v"})
+	require.NoError(t, err)
+	assert.Equal(t, "{{s5:v3=Zm9v}}", output)
+
+	_, err = transitOutput(transitEncrypt, map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestTransitBatchErrors(t *testing.T) {
+	f := newFakeVault(t)
+	f.Forbidden["forbidden"] = true
+
+	ciphered := &transitItem{TransitKey: "foo", Input: "bar"}
+	v.transitBatch(transitEncrypt, []*transitItem{ciphered})
+	require.NoError(t, ciphered.Err)
+
+	valid := &transitItem{TransitKey: "foo", Input: ciphered.Output}
+	invalid := &transitItem{TransitKey: "foo", Input: "{{s5:Zm9v}}"}
+	forbidden := &transitItem{TransitKey: "forbidden", Input: ciphered.Output}
+	missing := &transitItem{TransitKey: "missing", Input: ciphered.Output}
+	v.transitBatch(transitDecrypt, []*transitItem{valid, invalid, forbidden, missing})
+
+	require.NoError(t, valid.Err)
+	assert.Equal(t, "bar", valid.Output)
+
+	// A value which Vault refuses to decipher is not inaccessible, it is invalid
+	require.Error(t, invalid.Err)
+	assert.False(t, isInaccessible(invalid.Err))
+	assert.Contains(t, invalid.Err.Error(), "message authentication failed")
+
+	assert.True(t, isInaccessible(forbidden.Err))
+	assert.True(t, isInaccessible(missing.Err))
+}
+
+func TestFetchLocalValuesInvalidCiphertext(t *testing.T) {
+	newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+	s.SetVaultTransitKey("foo")
+	s.setSecretKey("foo", "bar", SecretKey{Value: "{{s5:Zm9v}}"})
+	v.cipher("foo", "bar")
+
+	_, err := fetchLocalValues()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to decode foo:bar from the statefile")
+
+	_, err = v.Decipher("foo", "bar", s.ReadSecretKey("foo", "bar").Value)
+	assert.Error(t, err)
+}
+
+func TestTransitBatchPartialFailure(t *testing.T) {
+	f := newFakeVault(t)
+	ciphertext := v.cipher("foo", "bar")
+
+	decipher := func() (valid, invalid *transitItem) {
+		f.Requests = nil
+		valid = &transitItem{TransitKey: "foo", Input: ciphertext}
+		invalid = &transitItem{TransitKey: "foo", Input: "{{s5:Zm9v}}"}
+		v.transitBatch(transitDecrypt, []*transitItem{valid, invalid})
+
+		require.NoError(t, valid.Err)
+		assert.Equal(t, "bar", valid.Output)
+		require.Error(t, invalid.Err)
+		return
+	}
+
+	// The errors of the items are read from the batch_results, within a single request
+	_, invalid := decipher()
+	assert.Contains(t, invalid.Err.Error(), "message authentication failed")
+	assert.Equal(t, []string{"PUT transit/decrypt/foo"}, f.Requests)
+
+	// Servers which reject the parameter get the batch again, and its items one by one
+	f.Legacy = true
+	decipher()
+	assert.Len(t, f.Requests, 4)
+}
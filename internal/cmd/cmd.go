@@ -1,232 +1,799 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"reflect"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/fatih/color"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
 // Init ..
-func Init(_ *cli.Context) (int, error) {
+func Init(ctx *cli.Context) (int, error) {
+	layout := ctx.String("layout")
+	if layout == "" {
+		layout = layoutFile
+	}
+
+	if err := validateLayout(layout); err != nil {
+		return 1, err
+	}
+
+	if layout == layoutDirectory {
+		if err := os.MkdirAll(s.Config.Path, 0o750); err != nil {
+			return 1, err
+		}
+	}
+
 	s.Init()
 	return 0, nil
 }
 
+// StatusResult : Information about both the statefile and the Vault cluster
+type StatusResult struct {
+	State StateStatus  `json:"state" yaml:"state"`
+	Vault *VaultStatus `json:"vault" yaml:"vault"`
+}
+
 // Status ..
 func Status(_ *cli.Context) (int, error) {
 	s.Load()
-	s.Status()
-	v.Status()
+	vs, err := v.Status()
+	if err != nil {
+		return 1, err
+	}
+
+	if err = render(&StatusResult{State: s.Status(), Vault: vs}); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
+func (r *StatusResult) renderTable(w io.Writer) {
+	r.State.renderTable(w)
+	r.Vault.renderTable(w)
+}
+
 // Plan ..
-func Plan(_ *cli.Context) (int, error) {
-	return run("plan")
+func Plan(ctx *cli.Context) (int, error) {
+	sel, err := selectorFromContext(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	s.Load()
+
+	defer s.SelectTarget(s.selected)
+	r := &PlanResults{Targets: []*PlanResult{}}
+	p := newPlanFile()
+	for _, target := range s.selectedTargetNames() {
+		s.SelectTarget(target)
+		if err := overrideDeleteMode(ctx); err != nil {
+			return 1, err
+		}
+
+		changes, _, remote, err := targetChanges(sel)
+		if err != nil {
+			return 1, fmt.Errorf("target '%v': %v", target, err)
+		}
+
+		r.Targets = append(r.Targets, newPlanResult(target, changes, kvDeleteMode()))
+		p.addTarget(changes, remote)
+	}
+
+	if err := render(r); err != nil {
+		return 1, err
+	}
+
+	if ctx.String("out") != "" {
+		if err := p.save(ctx.String("out")); err != nil {
+			return 1, err
+		}
+		log.Infof("Plan saved at %v, use 'strongbox apply %v' to apply it", ctx.String("out"), ctx.String("out"))
+	}
+
+	return 0, nil
 }
 
 // Apply ..
-func Apply(_ *cli.Context) (int, error) {
-	return run("apply")
-}
+func Apply(ctx *cli.Context) (int, error) {
+	if ctx.NArg() > 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
 
-func run(action string) (int, error) {
 	s.Load()
+	if ctx.NArg() == 1 {
+		if ctx.String("delete-mode") != "" {
+			return 1, fmt.Errorf("--delete-mode cannot be used when applying a plan file, the one recorded in the plan is used")
+		}
+
+		if ctx.String("selector") != "" {
+			return 1, fmt.Errorf("--selector cannot be used when applying a plan file, it has to be provided to 'strongbox plan'")
+		}
+		return applyPlanFile(ctx.Args().First())
+	}
+
+	sel, err := selectorFromContext(ctx)
+	if err != nil {
+		return 1, err
+	}
 
-	// Fetch local values
-	local := make(map[string]map[string]string)
-	for k, l := range s.Secrets {
-		if local[k] == nil {
-			local[k] = make(map[string]string)
+	defer s.SelectTarget(s.selected)
+
+	// Changes of every target are computed before applying any of them
+	type targetPlan struct {
+		changes *diff
+		local   *localSecrets
+		remote  *remoteSecrets
+	}
+
+	targets := s.selectedTargetNames()
+	plans := make(map[string]*targetPlan)
+	for _, target := range targets {
+		s.SelectTarget(target)
+		if err := overrideDeleteMode(ctx); err != nil {
+			return 1, err
 		}
-		for m, n := range l {
-			local[k][m] = v.Decipher(n)
+
+		changes, local, remote, err := targetChanges(sel)
+		if err != nil {
+			return 1, fmt.Errorf("target '%v': %v", target, err)
 		}
+		plans[target] = &targetPlan{changes, local, remote}
 	}
 
-	// Fetch remote values
-	remote := make(map[string]map[string]string)
-	listPath := s.VaultKVPath()
-	if s.VaultKVVersion() == 2 {
-		listPath = s.VaultKVPath() + "metadata"
+	// A target which cannot be fully applied does not prevent the others from being applied
+	results := &ApplyResults{}
+	for _, target := range targets {
+		s.SelectTarget(target)
+		var changes []AppliedChange
+		var err error
+		if !plans[target].changes.empty() {
+			changes, err = reconcile(plans[target].changes, plans[target].local, plans[target].remote)
+		}
+		results.add(target, kvDeleteMode(), changes, err)
 	}
 
-	d, err := v.Client.Logical().List(listPath)
+	return results.exit()
+}
+
+// ApplyResults : Changes performed onto the Vault KV of every target
+type ApplyResults struct {
+	Targets []*ApplyResult `json:"targets" yaml:"targets"`
+}
+
+// add : Records the outcome of the changes performed onto a target
+func (r *ApplyResults) add(target, deleteMode string, changes []AppliedChange, err error) {
+	t := &ApplyResult{Target: target, DeleteMode: deleteMode, Changes: changes}
+	if t.Changes == nil {
+		t.Changes = []AppliedChange{}
+	}
 	if err != nil {
+		t.Error = err.Error()
+	}
+	r.Targets = append(r.Targets, t)
+}
+
+// exit : Renders the results and returns an error if some changes could not be performed
+func (r *ApplyResults) exit() (int, error) {
+	if err := render(r); err != nil {
 		return 1, err
 	}
 
-	if d != nil {
-		if keys, ok := d.Data["keys"]; ok {
-			for _, k := range keys.([]interface{}) {
-				if remote[k.(string)] == nil {
-					remote[k.(string)] = make(map[string]string)
-				}
-
-				readPath := s.VaultKVPath() + k.(string)
-				if s.VaultKVVersion() == 2 {
-					readPath = s.VaultKVPath() + "data/" + k.(string)
-				}
-
-				l, err := v.Client.Logical().Read(readPath)
-				if err != nil {
-					return 1, err
-				}
-
-				if s.VaultKVVersion() == 2 {
-					if _, ok := l.Data["data"]; !ok {
-						return 1, fmt.Errorf("unable to parse content from Vault API response")
-					}
-
-					for m, n := range l.Data["data"].(map[string]interface{}) {
-						remote[k.(string)][m] = n.(string)
-					}
-				} else {
-					for m, n := range l.Data {
-						remote[k.(string)][m] = n.(string)
-					}
-				}
+	var failures []string
+	for _, t := range r.Targets {
+		if t.Error != "" {
+			failures = append(failures, fmt.Sprintf("target '%v': %v", t.Target, t.Error))
+		}
+	}
+
+	if len(failures) > 0 {
+		return 1, errors.New(strings.Join(failures, ", "))
+	}
+	return 0, nil
+}
+
+func (r *ApplyResults) renderTable(w io.Writer) {
+	for _, t := range r.Targets {
+		if len(r.Targets) > 1 {
+			fmt.Fprintf(w, "[%v]\n", t.Target)
+		}
+		t.renderTable(w)
+	}
+}
+
+// ApplyResult : Changes performed onto the Vault KV of a target
+type ApplyResult struct {
+	Target     string          `json:"target" yaml:"target"`
+	DeleteMode string          `json:"delete_mode,omitempty" yaml:"delete_mode,omitempty"`
+	Changes    []AppliedChange `json:"changes" yaml:"changes"`
+	Error      string          `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// AppliedChange : Change performed, or attempted, onto a secret of the Vault KV
+type AppliedChange struct {
+	Action string   `json:"action" yaml:"action"`
+	Secret string   `json:"secret" yaml:"secret"`
+	Keys   []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Error  string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Actions of the applied changes
+const (
+	appliedWrite      = "write"
+	appliedDeleteKeys = "delete_keys"
+	appliedLabel      = "label"
+	appliedDelete     = "delete"
+)
+
+func (r *ApplyResult) renderTable(w io.Writer) {
+	if len(r.Changes) == 0 && r.Error == "" {
+		color.New(color.FgGreen).Fprintln(w, "Nothing to do! Local state and remote Vault config are in sync.")
+		return
+	}
+
+	green, yellow, red := color.New(color.FgGreen), color.New(color.FgYellow), color.New(color.FgRed)
+	for _, c := range r.Changes {
+		if c.Error != "" {
+			red.Fprintf(w, "!> %v %v: %v\n", c.Action, c.Secret, c.Error)
+			continue
+		}
+
+		switch c.Action {
+		case appliedWrite:
+			green.Fprintf(w, "=> Added/Updated secret '%v' and managed keys\n", c.Secret)
+		case appliedDeleteKeys:
+			yellow.Fprintf(w, "=> Deleted keys %v of secret '%v'\n", c.Keys, c.Secret)
+		case appliedLabel:
+			yellow.Fprintf(w, "=> Updated labels of secret '%v'\n", c.Secret)
+		case appliedDelete:
+			red.Fprintf(w, "=> Deleted secret '%v' (%v)\n", c.Secret, r.DeleteMode)
+		}
+	}
+
+	if r.Error != "" {
+		red.Fprintf(w, "Error: %v\n", r.Error)
+	}
+}
+
+// targetChanges : Returns the changes required to reconcile the Vault KV of the
+// currently selected target with the statefile, alongside the values they were
+// computed from. Only the secrets matching the selector are considered.
+func targetChanges(sel selector) (changes *diff, local *localSecrets, remote *remoteSecrets, err error) {
+	if local, remote, err = fetchSelectedValues(sel); err != nil {
+		return
+	}
+
+	changes = computeDiff(local.Values, remote.Values)
+	changes.ignoreInaccessibleKeys(local.Inaccessible)
+	changes.ignoreDeletedSecrets(remote.unmanaged)
+	changes.ignoreDeletedKeys(s.isMerged)
+
+	if s.VaultKVVersion() == 2 && s.VaultKVSyncLabels() {
+		changes.UpdateLabels = remote.outdatedLabels(local)
+	}
+	return
+}
+
+// kvDeleteMode : Returns the deletion mode reported for the currently selected target,
+// KV v1 secrets are always deleted the same way and do not have any
+func kvDeleteMode() string {
+	if s.VaultKVVersion() != 2 {
+		return ""
+	}
+	return s.VaultKVDeleteMode()
+}
+
+// overrideDeleteMode : Uses the deletion mode provided on the command line, if any,
+// instead of the one configured in the statefile
+func overrideDeleteMode(ctx *cli.Context) error {
+	if ctx.String("delete-mode") == "" {
+		return validateDeleteMode(s.VaultKVDeleteMode())
+	}
+
+	if err := validateDeleteMode(ctx.String("delete-mode")); err != nil {
+		return err
+	}
+
+	s.target().Vault.KV.DeleteMode = ctx.String("delete-mode")
+	return nil
+}
+
+// fetchValues : Returns the deciphered local values alongside the remote secrets
+func fetchValues() (local *localSecrets, remote *remoteSecrets, err error) {
+	if local, err = fetchLocalValues(); err != nil {
+		return
+	}
+	remote, err = fetchRemoteSecrets()
+	return
+}
+
+// fetchSelectedValues : Returns the local and remote values of the secrets matching the
+// selector, remote secrets which are not defined in the statefile are omitted unless
+// the selector is empty
+func fetchSelectedValues(sel selector) (local *localSecrets, remote *remoteSecrets, err error) {
+	if local, remote, err = fetchValues(); err != nil {
+		return
+	}
+
+	selected := s.selectedBy(sel)
+	for _, values := range []map[string]map[string]interface{}{local.Values, remote.Values} {
+		for secret := range values {
+			if !selected(secret) {
+				delete(values, secret)
 			}
 		}
 	}
 
-	eq := reflect.DeepEqual(local, remote)
-	if eq {
-		color.Green("Nothing to do! Local state and remote Vault config are in synctx.")
-		return 0, nil
-	}
-
-	return reconcile(local, remote, action)
-}
-
-func reconcile(local map[string]map[string]string, remote map[string]map[string]string, action string) (int, error) {
-	var addSecret, deleteSecret []string
-	addSecretKey := make(map[string][]string)
-	deleteSecretKey := make(map[string][]string)
-	addSecretKeyCount := 0
-	deleteSecretKeyCount := 0
-
-	for kl, vl := range local {
-		foundSecret := false
-		for kr, vr := range remote {
-			if kl == kr {
-				foundSecret = true
-				for klk := range vl {
-					foundSecretKey := false
-					for krk := range vr {
-						if klk == krk {
-							foundSecretKey = true
-							break
-						}
-					}
-
-					if !foundSecretKey {
-						if addSecretKey[kl] == nil {
-							addSecretKey[kl] = make([]string, 0)
-						}
-						addSecretKey[kl] = append(addSecretKey[kl], klk)
-						addSecretKeyCount++
-					}
-				}
-				break
+	for secret := range local.Inaccessible {
+		if !selected(secret) {
+			delete(local.Inaccessible, secret)
+		}
+	}
+	return
+}
+
+// localSecrets : Secrets stored in the statefile
+type localSecrets struct {
+	// Values of the secrets, deciphered
+	Values map[string]map[string]interface{}
+
+	// Inaccessible lists the keys of the secrets which cannot be deciphered using the
+	// current token, they are omitted from Values
+	Inaccessible map[string][]string
+}
+
+// fetchLocalValues : Returns the deciphered values of the state file
+func fetchLocalValues() (*localSecrets, error) {
+	local := &localSecrets{
+		Values:       make(map[string]map[string]interface{}),
+		Inaccessible: make(map[string][]string),
+	}
+
+	values, errs := decipherValues(s.target().Secrets)
+	for _, k := range sortedKeys(s.target().Secrets) {
+		local.Values[k] = make(map[string]interface{})
+		for m, n := range values[k] {
+			local.Values[k][m] = n
+		}
+
+		for _, m := range sortedKeys(errs[k]) {
+			if err := errs[k][m]; isInaccessible(err) {
+				log.Debugf("Skipping %v:%v, %v", k, m, err)
+				local.Inaccessible[k] = append(local.Inaccessible[k], m)
+			} else {
+				return nil, fmt.Errorf("unable to decode %v:%v from the statefile: %v", k, m, err)
 			}
 		}
+	}
+	return local, nil
+}
+
+// remoteSecrets : Secrets currently stored in the Vault KV
+type remoteSecrets struct {
+	// Values of the secrets, the ones whose latest version has been deleted are omitted
+	Values map[string]map[string]interface{}
+
+	// Versions of the secrets, only available on KV v2
+	Versions map[string]int
+
+	// Managed lists the secrets marked as managed by strongbox, only available on KV v2
+	Managed map[string]bool
+
+	// CustomMetadata of the secrets, only available on KV v2
+	CustomMetadata map[string]map[string]interface{}
+}
+
+// fetchRemoteSecrets : Returns the secrets currently stored in the Vault KV
+func fetchRemoteSecrets() (*remoteSecrets, error) {
+	if s.VaultKVOwnership() == ownershipMarked && s.VaultKVVersion() != 2 {
+		return nil, fmt.Errorf("the '%v' ownership mode requires KV v2", ownershipMarked)
+	}
+
+	secrets, err := listRemoteSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	remote := &remoteSecrets{
+		Values:         make(map[string]map[string]interface{}),
+		Versions:       make(map[string]int),
+		Managed:        make(map[string]bool),
+		CustomMetadata: make(map[string]map[string]interface{}),
+	}
+
+	// The secrets are read concurrently, their content is then processed in order
+	var mu sync.Mutex
+	read := make(map[string]*kvSecret)
+	errs := forEachParallel(secrets, func(secret string) error {
+		ks, err := v.readSecret(secret)
+		if err != nil || ks == nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		read[secret] = ks
+		return nil
+	})
+
+	if err = kvError("read", errs); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range sortedKeys(read) {
+		ks := read[secret]
 
-		if !foundSecret {
-			addSecret = append(addSecret, kl)
-			addSecretKey[kl] = make([]string, 0)
-			for klk := range vl {
-				addSecretKey[kl] = append(addSecretKey[kl], klk)
-				addSecretKeyCount++
+		// Secrets whose latest version has been deleted are listed but have no content,
+		// their version is still required in order to be able to write them again
+		if ks.Data != nil {
+			if remote.Values[secret], err = ks.values(); err != nil {
+				return nil, fmt.Errorf("unable to read secret '%v' from Vault: %v", secret, err)
 			}
 		}
+
+		if ks.Version > 0 {
+			remote.Versions[secret] = ks.Version
+		}
+
+		if ks.managed() {
+			remote.Managed[secret] = true
+		}
+
+		if ks.CustomMetadata != nil {
+			remote.CustomMetadata[secret] = ks.CustomMetadata
+		}
+	}
+
+	return remote, nil
+}
+
+// unmanaged : Returns true if strongbox is not allowed to delete the remote secret
+func (r *remoteSecrets) unmanaged(secret string) bool {
+	// In merge mode, strongbox only owns the keys of the secret which are defined in the
+	// statefile, it can't delete the secret once it is not in the statefile anymore
+	if s.isIgnored(secret) || s.isMerged(secret) {
+		return true
 	}
 
-	for kr, vr := range remote {
-		foundSecret := false
-		for kl, vl := range local {
-			if kr == kl {
-				foundSecret = true
-				for krk := range vr {
-					foundSecretKey := false
-					for klk := range vl {
-						if krk == klk {
-							foundSecretKey = true
-							break
-						}
-					}
-
-					if !foundSecretKey {
-						fmt.Println(kr, krk)
-						if deleteSecretKey[kr] == nil {
-							deleteSecretKey[kr] = make([]string, 0)
-						}
-						deleteSecretKey[kr] = append(deleteSecretKey[kr], krk)
-						deleteSecretKeyCount++
-					}
-				}
+	return s.VaultKVOwnership() == ownershipMarked && !r.Managed[secret]
+}
+
+// outdatedLabels : Returns the labels of the local secrets which are missing from the
+// custom metadata of the remote ones, indexed by secret names
+func (r *remoteSecrets) outdatedLabels(local *localSecrets) map[string]map[string]string {
+	outdated := make(map[string]map[string]string)
+	for secret := range local.Values {
+		labels := s.SecretLabels(secret)
+		for key, value := range labels {
+			if r.CustomMetadata[secret][key] != value {
+				outdated[secret] = labels
 				break
 			}
 		}
+	}
+	return outdated
+}
+
+// listRemoteSecrets : Returns the name of the secrets stored in the Vault KV, folders
+// are walked recursively and their secrets are returned as '<folder>/<secret>'. The
+// folders of a same depth are listed concurrently
+func listRemoteSecrets() ([]string, error) {
+	var secrets []string
+	var mu sync.Mutex
+	for folders := []string{""}; len(folders) > 0; {
+		var subFolders []string
+		errs := forEachParallel(folders, func(folder string) error {
+			folderSecrets, folderSubFolders, err := listRemoteFolder(folder)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			secrets = append(secrets, folderSecrets...)
+			subFolders = append(subFolders, folderSubFolders...)
+			return nil
+		})
+
+		if err := kvError("list", errs); err != nil {
+			return nil, err
+		}
+		folders = subFolders
+	}
+
+	sort.Strings(secrets)
+	return secrets, nil
+}
+
+// listRemoteFolder : Returns the secrets and the sub-folders of a folder of the Vault KV
+func listRemoteFolder(folder string) (secrets, subFolders []string, err error) {
+	listPath := s.VaultKVPath() + folder
+	if s.VaultKVVersion() == 2 {
+		listPath = s.VaultKVPath() + "metadata/" + folder
+	}
+
+	d, err := v.Client.Logical().List(listPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if d == nil {
+		return
+	}
+
+	keys, ok := d.Data["keys"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, k := range keys {
+		name, ok := k.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to parse the list of secrets from Vault API response")
+		}
+
+		if strings.HasSuffix(name, "/") {
+			subFolders = append(subFolders, folder+name)
+		} else {
+			secrets = append(secrets, folder+name)
+		}
+	}
+
+	return
+}
+
+// readRemoteSecret : Returns the values of a secret stored in the Vault KV, nil if
+// the secret does not exist
+func readRemoteSecret(secret string) (map[string]interface{}, error) {
+	ks, err := v.readSecret(secret)
+	if err != nil || ks == nil || ks.Data == nil {
+		return nil, err
+	}
+
+	values, err := ks.values()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret '%v' from Vault: %v", secret, err)
+	}
+	return values, nil
+}
+
+// PlanResults : Results of the comparison of every target of the statefile
+type PlanResults struct {
+	Targets []*PlanResult `json:"targets" yaml:"targets"`
+}
+
+func (r *PlanResults) renderTable(w io.Writer) {
+	for _, t := range r.Targets {
+		if len(r.Targets) > 1 {
+			fmt.Fprintf(w, "[%v]\n", t.Target)
+		}
+		t.renderTable(w)
+	}
+}
+
+// PlanResult : Result of the comparison between the local state and the remote Vault KV
+type PlanResult struct {
+	Target     string      `json:"target" yaml:"target"`
+	Add        PlanSummary `json:"add" yaml:"add"`
+	Update     PlanSummary `json:"update" yaml:"update"`
+	Remove     PlanSummary `json:"remove" yaml:"remove"`
+	Labels     int         `json:"labels,omitempty" yaml:"labels,omitempty"`
+	DeleteMode string      `json:"delete_mode,omitempty" yaml:"delete_mode,omitempty"`
+	Ignored    []string    `json:"ignored,omitempty" yaml:"ignored,omitempty"`
+
+	// Inaccessible keys cannot be deciphered using the current token, they are skipped
+	Inaccessible map[string][]string `json:"inaccessible,omitempty" yaml:"inaccessible,omitempty"`
+
+	Operations []PlanOperation `json:"operations" yaml:"operations"`
+}
+
+// PlanSummary : Amount of secrets and keys affected by a type of operation
+type PlanSummary struct {
+	Secrets int `json:"secrets" yaml:"secrets"`
+	Keys    int `json:"keys" yaml:"keys"`
+}
 
-		if !foundSecret {
-			deleteSecret = append(deleteSecret, kr)
-			deleteSecretKey[kr] = make([]string, 0)
-			for krk := range vr {
-				deleteSecretKey[kr] = append(deleteSecretKey[kr], krk)
-				deleteSecretKeyCount++
+func newPlanResult(target string, d *diff, deleteMode string) *PlanResult {
+	return &PlanResult{
+		Target:       target,
+		DeleteMode:   deleteMode,
+		Ignored:      d.IgnoredSecrets,
+		Inaccessible: d.Inaccessible,
+		Add:          PlanSummary{Secrets: len(d.AddSecrets), Keys: d.count(d.AddKeys)},
+		Update:       PlanSummary{Secrets: len(d.UpdateKeys), Keys: d.count(d.UpdateKeys)},
+		Remove:       PlanSummary{Secrets: len(d.DeleteSecrets), Keys: d.count(d.DeleteKeys)},
+		Labels:       len(d.UpdateLabels),
+		Operations:   append([]PlanOperation{}, d.operations()...),
+	}
+}
+
+func (r *PlanResult) renderTable(w io.Writer) {
+	for _, secret := range r.Ignored {
+		fmt.Fprintf(w, "-> %v (ignored, not managed by strongbox)\n", secret)
+	}
+
+	if len(r.Inaccessible) > 0 {
+		count := 0
+		for _, secret := range sortedKeys(r.Inaccessible) {
+			for _, key := range r.Inaccessible[secret] {
+				fmt.Fprintf(w, "?> %v:%v (inaccessible, cannot be deciphered with the current token)\n", secret, key)
+				count++
 			}
 		}
+		color.New(color.FgYellow).Fprintf(w, "Inaccessible: %v key(s) skipped\n", count)
+	}
+
+	if len(r.Operations) == 0 {
+		color.New(color.FgGreen).Fprintln(w, "Nothing to do! Local state and remote Vault config are in sync.")
+		return
 	}
 
-	switch action {
-	case "plan":
-		if (len(addSecret) > 0) || (addSecretKeyCount > 0) {
-			color.Green("Add/Update: %v secret(s) and %v key(s)", len(addSecret), addSecretKeyCount)
-			for k, l := range addSecretKey {
-				for _, m := range l {
-					color.Green("=> %v:%v", k, m)
-				}
+	green, yellow, red := color.New(color.FgGreen), color.New(color.FgYellow), color.New(color.FgRed)
+	if r.Add.Secrets > 0 || r.Add.Keys > 0 {
+		green.Fprintf(w, "Add: %v secret(s) and %v key(s)\n", r.Add.Secrets, r.Add.Keys)
+		for _, o := range r.Operations {
+			if o.Action == "add" {
+				green.Fprintf(w, "=> %v:%v\n", o.Secret, o.Key)
 			}
 		}
-		if (len(deleteSecret) > 0) || (deleteSecretKeyCount > 0) {
-			color.Red("Remove: %v secret(s) and %v key(s)", len(deleteSecret), deleteSecretKeyCount)
-			for k, l := range deleteSecretKey {
-				for _, m := range l {
-					color.Red("=> %v:%v", k, m)
-				}
+	}
+
+	if r.Update.Keys > 0 {
+		yellow.Fprintf(w, "Update: %v key(s)\n", r.Update.Keys)
+		for _, o := range r.Operations {
+			if o.Action == "update" {
+				yellow.Fprintf(w, "~> %v:%v (value changed)\n", o.Secret, o.Key)
 			}
 		}
-	case "apply":
-		for _, k := range addSecret {
-			payload := make(map[string]interface{})
-			for m, n := range local[k] {
-				payload[m] = n
+	}
+
+	if r.Labels > 0 {
+		yellow.Fprintf(w, "Labels: %v secret(s)\n", r.Labels)
+		for _, o := range r.Operations {
+			if o.Action == "label" {
+				yellow.Fprintf(w, "~> %v (labels: %v)\n", o.Secret, formatLabels(o.Labels))
 			}
-			v.WriteSecret(k, payload)
 		}
-		for k := range addSecretKey {
-			payload := make(map[string]interface{})
-			for m, n := range local[k] {
-				payload[m] = n
+	}
+
+	if r.Remove.Secrets > 0 || r.Remove.Keys > 0 {
+		red.Fprintf(w, "Remove: %v secret(s) and %v key(s)\n", r.Remove.Secrets, r.Remove.Keys)
+		if r.Remove.Secrets > 0 && r.DeleteMode != "" {
+			red.Fprintf(w, "Secrets will be deleted using the '%v' mode (%v)\n", r.DeleteMode, deleteModeDescriptions[r.DeleteMode])
+		}
+		for _, o := range r.Operations {
+			if o.Action != "delete" {
+				continue
+			}
+
+			if o.Key != "" {
+				red.Fprintf(w, "=> %v:%v\n", o.Secret, o.Key)
+			}
+
+			for _, key := range o.Keys {
+				red.Fprintf(w, "=> %v:%v\n", o.Secret, key)
 			}
-			v.WriteSecret(k, payload)
 		}
-		for _, k := range deleteSecret {
-			v.DeleteSecret(k)
+	}
+}
+
+// reconcile : Applies the changes onto the Vault KV of the currently selected target
+func reconcile(d *diff, local *localSecrets, remote *remoteSecrets) ([]AppliedChange, error) {
+	c := &kvChanges{
+		Writes:        make(map[string]map[string]interface{}),
+		DeleteKeys:    make(map[string][]string),
+		Versions:      remote.Versions,
+		Labels:        d.UpdateLabels,
+		DeleteSecrets: d.DeleteSecrets,
+	}
+
+	for _, k := range d.changedSecrets() {
+		if d.onlyDeletesKeys(k) {
+			c.DeleteKeys[k] = d.DeleteKeys[k]
+			continue
 		}
-		for k := range deleteSecretKey {
-			payload := make(map[string]interface{})
-			for m, n := range local[k] {
+
+		// Remote values of the keys which are not managed by strongbox or cannot be
+		// deciphered locally are written back as they are
+		payload := make(map[string]interface{})
+		for m, n := range remote.Values[k] {
+			if s.isMerged(k) || d.inaccessible(k, m) {
 				payload[m] = n
 			}
-			v.WriteSecret(k, payload)
 		}
-	default:
-		return 1, fmt.Errorf("No action specified")
+
+		for m, n := range local.Values[k] {
+			payload[m] = n
+		}
+		c.Writes[k] = payload
 	}
 
-	return 0, nil
+	return c.apply()
+}
+
+// kvChanges : Changes to perform onto the secrets of the Vault KV
+type kvChanges struct {
+	// Writes holds the complete payloads of the secrets to write
+	Writes map[string]map[string]interface{}
+
+	// DeleteKeys holds the keys to remove from secrets which are not written otherwise
+	DeleteKeys map[string][]string
+
+	// Versions of the secrets, KV v2 writes only succeed if they still match
+	Versions map[string]int
+
+	// Labels to write into the custom metadata of the secrets
+	Labels map[string]map[string]string
+
+	// DeleteSecrets lists the secrets to delete
+	DeleteSecrets []string
+}
+
+// apply : Performs the changes onto the Vault KV of the currently selected target using
+// a pool of workers. A failure only affects its own secret, the changes are returned in a
+// stable order once all of them have been attempted
+func (c *kvChanges) apply() ([]AppliedChange, error) {
+	writes, deleteKeys, deletes := sortedKeys(c.Writes), sortedKeys(c.DeleteKeys), append([]string{}, c.DeleteSecrets...)
+	sort.Strings(deletes)
+
+	errs := forEachParallel(append(append([]string{}, writes...), deleteKeys...), func(secret string) error {
+		if payload, found := c.Writes[secret]; found {
+			return v.WriteSecret(secret, payload, c.Versions[secret])
+		}
+		return v.DeleteSecretKeys(secret, c.DeleteKeys[secret], c.Versions[secret])
+	})
+
+	changes := []AppliedChange{}
+	for _, secret := range writes {
+		changes = append(changes, appliedChange(appliedWrite, secret, nil, errs[secret]))
+	}
+	for _, secret := range deleteKeys {
+		changes = append(changes, appliedChange(appliedDeleteKeys, secret, c.DeleteKeys[secret], errs[secret]))
+	}
+
+	// The labels of the secrets which could not be written are left untouched
+	var labelled []string
+	for _, secret := range sortedKeys(c.Labels) {
+		if errs[secret] == nil {
+			labelled = append(labelled, secret)
+		}
+	}
+
+	labelErrs := forEachParallel(labelled, func(secret string) error {
+		return v.WriteSecretLabels(secret, c.Labels[secret])
+	})
+	for _, secret := range labelled {
+		changes = append(changes, appliedChange(appliedLabel, secret, nil, labelErrs[secret]))
+		if labelErrs[secret] != nil {
+			errs[secret] = labelErrs[secret]
+		}
+	}
+
+	deleteErrs := forEachParallel(deletes, v.DeleteSecret)
+	for _, secret := range deletes {
+		changes = append(changes, appliedChange(appliedDelete, secret, nil, deleteErrs[secret]))
+		if deleteErrs[secret] != nil {
+			errs[secret] = deleteErrs[secret]
+		}
+	}
+
+	return changes, kvError("update", errs)
+}
+
+func appliedChange(action, secret string, keys []string, err error) AppliedChange {
+	c := AppliedChange{Action: action, Secret: secret, Keys: keys}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
 }
@@ -1,3 +1,233 @@
 package cmd
 
-// TODO: Implement
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchRemoteSecretsVersions(t *testing.T) {
+	f := newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+	f.KV["secret"]["foo"] = &fakeSecret{Versions: []*fakeSecretVersion{
+		{Data: map[string]interface{}{"bar": "1"}},
+		{Data: map[string]interface{}{"bar": "2"}},
+	}}
+	f.KV["secret"]["deleted"] = &fakeSecret{Versions: []*fakeSecretVersion{
+		{Data: map[string]interface{}{"bar": "1"}, Deleted: true},
+	}}
+
+	// Secrets whose latest version has been deleted have no values but still a version
+	remote, err := fetchRemoteSecrets()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]interface{}{"foo": {"bar": "2"}}, remote.Values)
+	assert.Equal(t, map[string]int{"foo": 2, "deleted": 1}, remote.Versions)
+
+	// The versions which have been read are the ones the writes are checked against
+	f.KV["secret"]["foo"].Versions = append(f.KV["secret"]["foo"].Versions, &fakeSecretVersion{Data: map[string]interface{}{"bar": "3"}})
+	c := &kvChanges{
+		Writes: map[string]map[string]interface{}{
+			"foo":     {"bar": "4"},
+			"deleted": {"bar": "2"},
+		},
+		Versions: remote.Versions,
+	}
+
+	changes, err := c.apply()
+	assert.EqualError(t, err, "unable to update 1 path(s) of the Vault KV")
+	require.Len(t, changes, 2)
+	assert.Empty(t, changes[0].Error)
+	assert.Contains(t, changes[1].Error, "secret 'foo' has been updated in Vault since it was read")
+	assert.Equal(t, map[string]interface{}{"bar": "2"}, f.KV["secret"]["deleted"].data())
+	assert.Equal(t, map[string]interface{}{"bar": "3"}, f.KV["secret"]["foo"].data())
+}
+
+func TestFetchRemoteSecretsOwnership(t *testing.T) {
+	f := newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+	s.SetVaultKVOwnership(ownershipMarked)
+	require.NoError(t, s.AddVaultKVIgnore("ignored"))
+	f.KV["secret"]["ours"] = &fakeSecret{
+		Versions:       []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}},
+		CustomMetadata: map[string]interface{}{managedByKey: managedByValue},
+	}
+	f.KV["secret"]["ignored"] = &fakeSecret{
+		Versions:       []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}},
+		CustomMetadata: map[string]interface{}{managedByKey: managedByValue},
+	}
+	f.KV["secret"]["theirs"] = &fakeSecret{
+		Versions:       []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}},
+		CustomMetadata: map[string]interface{}{"team": "a"},
+	}
+
+	// Only the marked secrets which are not ignored can be deleted
+	changes, _, remote, err := targetChanges(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ours": true, "ignored": true}, remote.Managed)
+	assert.Equal(t, []string{"ours"}, changes.DeleteSecrets)
+
+	// Secrets written by strongbox get marked, their other custom metadata are preserved
+	require.NoError(t, v.WriteSecret("theirs", map[string]interface{}{"bar": "2"}, 1))
+	require.NoError(t, v.WriteSecret("new", map[string]interface{}{"bar": "1"}, 0))
+	assert.Equal(t, map[string]interface{}{"team": "a", managedByKey: managedByValue}, f.KV["secret"]["theirs"].CustomMetadata)
+	assert.Equal(t, map[string]interface{}{managedByKey: managedByValue}, f.KV["secret"]["new"].CustomMetadata)
+
+	// Marking the secrets requires the KV v2 custom metadata
+	s.SetVaultKVVersion(1)
+	_, err = fetchRemoteSecrets()
+	assert.EqualError(t, err, "the 'marked' ownership mode requires KV v2")
+}
+
+func TestListRemoteSecretsNested(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		f := newFakeVault(t)
+		f.KV["kv"] = make(map[string]*fakeSecret)
+		f.KVVersions["kv"] = version
+		s = getTestStateClient()
+		s.Init()
+		s.SetVaultKVPath("kv/")
+		s.SetVaultKVVersion(version)
+		for _, secret := range []string{"top", "app/env/db", "app/env/cache", "app/other", "team/a/b/c"} {
+			f.KV["kv"][secret] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": secret}}}}
+		}
+
+		// Folders are walked recursively
+		secrets, err := listRemoteSecrets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"app/env/cache", "app/env/db", "app/other", "team/a/b/c", "top"}, secrets)
+
+		// Nested secrets are read and written at their own path
+		values, err := readRemoteSecret("app/env/db")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"bar": "app/env/db"}, values)
+
+		f.Requests = nil
+		require.NoError(t, v.WriteSecret("app/new/db", map[string]interface{}{"bar": "1"}, 0))
+		assert.Equal(t, map[string]interface{}{"bar": "1"}, f.KV["kv"]["app/new/db"].data())
+		if version == 2 {
+			assert.Equal(t, []string{"PUT kv/data/app/new/db"}, f.Requests)
+		} else {
+			assert.Equal(t, []string{"PUT kv/app/new/db"}, f.Requests)
+		}
+	}
+}
+
+func TestReconcileTargets(t *testing.T) {
+	f := newFakeVault(t)
+	f.KV["kv-team"] = map[string]*fakeSecret{"old": {Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}}}}
+	s = getTestStateClient()
+	s.Init()
+	s.setSecretKey("foo", "bar", SecretKey{Value: v.cipher("default", "1")})
+	require.NoError(t, s.AddTarget("team"))
+	s.SelectTarget("team")
+	s.SetVaultKVPath("kv-team/")
+	s.SetVaultKVVersion(1)
+	s.SetVaultTransitKey("team")
+	s.setSecretKey("baz", "qux", SecretKey{Value: v.cipher("team", "2")})
+
+	// Each target is reconciled with its own mount, using its own transit key
+	f.Requests = nil
+	for _, target := range s.TargetNames() {
+		s.SelectTarget(target)
+		changes, local, remote, err := targetChanges(nil)
+		require.NoError(t, err)
+		_, err = reconcile(changes, local, remote)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, map[string]*fakeSecret{"foo": f.KV["secret"]["foo"]}, f.KV["secret"])
+	assert.Equal(t, map[string]interface{}{"bar": "1"}, f.KV["secret"]["foo"].data())
+	assert.Equal(t, map[string]*fakeSecret{"baz": f.KV["kv-team"]["baz"]}, f.KV["kv-team"])
+	assert.Equal(t, map[string]interface{}{"qux": "2"}, f.KV["kv-team"]["baz"].data())
+	assert.Subset(t, f.Requests, []string{"PUT transit/decrypt/default", "PUT secret/data/foo", "PUT transit/decrypt/team", "PUT kv-team/baz", "DELETE kv-team/old"})
+}
+
+func TestKVDeleteMode(t *testing.T) {
+	s = getTestStateClient()
+	s.Init()
+	s.SetVaultKVDeleteMode(deleteModeDestroy)
+	assert.Equal(t, deleteModeDestroy, kvDeleteMode())
+
+	// Plan and apply do not report any deletion mode for KV v1 targets
+	s.SetVaultKVVersion(1)
+	assert.Empty(t, kvDeleteMode())
+}
+
+func TestKVChangesApply(t *testing.T) {
+	defer func() { parallelism = defaultParallelism }()
+	require.NoError(t, setParallelism(4))
+
+	f := newFakeVault(t)
+	f.Latency = 10 * time.Millisecond
+	f.Failing["broken"] = true
+	s = getTestStateClient()
+	s.Init()
+	f.KV["secret"]["stale"] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}}}
+	f.KV["secret"]["keys"] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1", "baz": "2"}}}}
+	f.KV["secret"]["old"] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}}}
+
+	c := &kvChanges{
+		Writes: map[string]map[string]interface{}{
+			"broken": {"bar": "1"},
+			"stale":  {"bar": "2"},
+		},
+		DeleteKeys: map[string][]string{"keys": {"bar"}},
+		// The version of 'stale' is missing, as if it had been created after being read
+		Versions: map[string]int{"keys": 1},
+		Labels: map[string]map[string]string{
+			"broken":   {"team": "a"},
+			"secret-0": {"team": "b"},
+		},
+		DeleteSecrets: []string{"old"},
+	}
+	for i := 7; i >= 0; i-- {
+		c.Writes[fmt.Sprintf("secret-%d", i)] = map[string]interface{}{"bar": fmt.Sprint(i)}
+	}
+
+	changes, err := c.apply()
+	assert.EqualError(t, err, "unable to update 2 path(s) of the Vault KV")
+
+	// The requests overlap but never exceed the configured parallelism
+	assert.Greater(t, f.MaxInFlight(), 1)
+	assert.LessOrEqual(t, f.MaxInFlight(), 4)
+
+	// The results are returned sorted, whatever the order in which they completed
+	var actions []string
+	for _, change := range changes {
+		actions = append(actions, change.Action+" "+change.Secret)
+	}
+	assert.Equal(t, []string{
+		"write broken",
+		"write secret-0", "write secret-1", "write secret-2", "write secret-3",
+		"write secret-4", "write secret-5", "write secret-6", "write secret-7",
+		"write stale",
+		"delete_keys keys",
+		"label secret-0",
+		"delete old",
+	}, actions)
+
+	for _, change := range changes {
+		switch change.Secret {
+		case "broken":
+			assert.Contains(t, change.Error, "internal error")
+		case "stale":
+			assert.Contains(t, change.Error, "secret 'stale' has been updated in Vault since it was read")
+		default:
+			assert.Empty(t, change.Error, change.Secret)
+		}
+	}
+	assert.Equal(t, []string{"bar"}, changes[10].Keys)
+
+	// A failure only affects its own secret
+	assert.NotContains(t, f.KV["secret"], "broken")
+	assert.Equal(t, map[string]interface{}{"bar": "1"}, f.KV["secret"]["stale"].data())
+	assert.Equal(t, map[string]interface{}{"bar": "3"}, f.KV["secret"]["secret-3"].data())
+	assert.Equal(t, map[string]interface{}{"team": "b"}, f.KV["secret"]["secret-0"].CustomMetadata)
+	assert.Equal(t, map[string]interface{}{"baz": "2"}, f.KV["secret"]["keys"].data())
+	assert.Nil(t, f.KV["secret"]["old"].data())
+}
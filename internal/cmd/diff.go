@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"reflect"
+	"sort"
+)
+
+// diff : Holds the differences between the local state and the remote Vault KV
+type diff struct {
+	AddSecrets    []string
+	DeleteSecrets []string
+	AddKeys       map[string][]string
+	UpdateKeys    map[string][]string
+	DeleteKeys    map[string][]string
+
+	// IgnoredSecrets only exist on the remote side but are not managed by strongbox
+	IgnoredSecrets []string
+
+	// Inaccessible keys cannot be deciphered locally, they are excluded from the diff
+	Inaccessible map[string][]string
+
+	// UpdateLabels holds the labels to write into the custom metadata of the secrets
+	UpdateLabels map[string]map[string]string
+}
+
+// computeDiff : Compares local and remote values and returns what needs to be
+// added, updated or removed on the remote side to match the local state
+func computeDiff(local, remote map[string]map[string]interface{}) *diff {
+	d := &diff{
+		AddKeys:    make(map[string][]string),
+		UpdateKeys: make(map[string][]string),
+		DeleteKeys: make(map[string][]string),
+	}
+
+	for secret, keys := range local {
+		r, found := remote[secret]
+		if !found {
+			d.AddSecrets = append(d.AddSecrets, secret)
+		}
+
+		for key, value := range keys {
+			remoteValue, found := r[key]
+			switch {
+			case !found:
+				d.AddKeys[secret] = append(d.AddKeys[secret], key)
+			case !reflect.DeepEqual(remoteValue, value):
+				d.UpdateKeys[secret] = append(d.UpdateKeys[secret], key)
+			}
+		}
+	}
+
+	for secret, keys := range remote {
+		l, found := local[secret]
+		if !found {
+			d.DeleteSecrets = append(d.DeleteSecrets, secret)
+		}
+
+		for key := range keys {
+			if _, found := l[key]; !found {
+				d.DeleteKeys[secret] = append(d.DeleteKeys[secret], key)
+			}
+		}
+	}
+
+	sort.Strings(d.AddSecrets)
+	sort.Strings(d.DeleteSecrets)
+	for _, m := range []map[string][]string{d.AddKeys, d.UpdateKeys, d.DeleteKeys} {
+		for _, keys := range m {
+			sort.Strings(keys)
+		}
+	}
+
+	return d
+}
+
+// ignoreInaccessibleKeys : Excludes the keys which cannot be deciphered locally from
+// the diff, they are neither added, updated nor deleted
+func (d *diff) ignoreInaccessibleKeys(inaccessible map[string][]string) {
+	d.Inaccessible = make(map[string][]string)
+	for secret, keys := range inaccessible {
+		if len(keys) == 0 {
+			continue
+		}
+		d.Inaccessible[secret] = append([]string{}, keys...)
+		sort.Strings(d.Inaccessible[secret])
+	}
+
+	for _, m := range []map[string][]string{d.AddKeys, d.UpdateKeys, d.DeleteKeys} {
+		for secret, keys := range m {
+			var accessible []string
+			for _, key := range keys {
+				if !d.inaccessible(secret, key) {
+					accessible = append(accessible, key)
+				}
+			}
+
+			if len(accessible) == 0 {
+				delete(m, secret)
+				continue
+			}
+			m[secret] = accessible
+		}
+	}
+
+	// Secrets are not added if none of their keys is accessible, and never deleted as a
+	// whole if some of their keys are inaccessible
+	var addSecrets, deleteSecrets []string
+	for _, secret := range d.AddSecrets {
+		if _, found := d.Inaccessible[secret]; !found || len(d.AddKeys[secret]) > 0 {
+			addSecrets = append(addSecrets, secret)
+		}
+	}
+
+	for _, secret := range d.DeleteSecrets {
+		if _, found := d.Inaccessible[secret]; !found {
+			deleteSecrets = append(deleteSecrets, secret)
+		}
+	}
+
+	d.AddSecrets, d.DeleteSecrets = addSecrets, deleteSecrets
+}
+
+// inaccessible : Returns true if the key of the secret cannot be deciphered locally
+func (d *diff) inaccessible(secret, key string) bool {
+	for _, k := range d.Inaccessible[secret] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreDeletedSecrets : Moves the secrets which would be deleted but are not
+// managed by strongbox into the ignored ones
+func (d *diff) ignoreDeletedSecrets(unmanaged func(secret string) bool) {
+	var secrets []string
+	for _, secret := range d.DeleteSecrets {
+		if unmanaged(secret) {
+			d.IgnoredSecrets = append(d.IgnoredSecrets, secret)
+			delete(d.DeleteKeys, secret)
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	d.DeleteSecrets = secrets
+}
+
+// ignoreAddedSecrets : Moves the secrets which would be added but are not managed
+// by strongbox into the ignored ones
+func (d *diff) ignoreAddedSecrets(unmanaged func(secret string) bool) {
+	var secrets []string
+	for _, secret := range d.AddSecrets {
+		if unmanaged(secret) {
+			d.IgnoredSecrets = append(d.IgnoredSecrets, secret)
+			delete(d.AddKeys, secret)
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	d.AddSecrets = secrets
+}
+
+// ignoreDeletedKeys : Stops reporting the deletion of the keys of the secrets for
+// which unmanaged returns true
+func (d *diff) ignoreDeletedKeys(unmanaged func(secret string) bool) {
+	for secret := range d.DeleteKeys {
+		if unmanaged(secret) {
+			delete(d.DeleteKeys, secret)
+		}
+	}
+}
+
+// ignoreAddedKeys : Stops reporting the addition of the keys of the secrets for
+// which unmanaged returns true
+func (d *diff) ignoreAddedKeys(unmanaged func(secret string) bool) {
+	for secret := range d.AddKeys {
+		if unmanaged(secret) {
+			delete(d.AddKeys, secret)
+		}
+	}
+}
+
+// empty : Returns true if there is nothing to reconcile
+func (d *diff) empty() bool {
+	return len(d.AddSecrets) == 0 &&
+		len(d.DeleteSecrets) == 0 &&
+		len(d.AddKeys) == 0 &&
+		len(d.UpdateKeys) == 0 &&
+		len(d.DeleteKeys) == 0 &&
+		len(d.UpdateLabels) == 0
+}
+
+// count : Returns the total amount of keys referenced in m
+func (d *diff) count(m map[string][]string) (c int) {
+	for _, keys := range m {
+		c += len(keys)
+	}
+	return
+}
+
+// changedSecrets : Returns the sorted list of secrets which exist locally
+// and need to be written onto Vault
+func (d *diff) changedSecrets() (secrets []string) {
+	deleted := make(map[string]bool)
+	for _, secret := range d.DeleteSecrets {
+		deleted[secret] = true
+	}
+
+	changed := make(map[string][]string)
+	for _, secret := range d.AddSecrets {
+		changed[secret] = nil
+	}
+	for _, m := range []map[string][]string{d.AddKeys, d.UpdateKeys, d.DeleteKeys} {
+		for secret := range m {
+			if !deleted[secret] {
+				changed[secret] = nil
+			}
+		}
+	}
+
+	return sortedKeys(changed)
+}
+
+// onlyDeletesKeys : Returns true if the only changes to make on an existing
+// remote secret are key deletions
+func (d *diff) onlyDeletesKeys(secret string) bool {
+	for _, k := range d.AddSecrets {
+		if k == secret {
+			return false
+		}
+	}
+
+	return len(d.AddKeys[secret]) == 0 &&
+		len(d.UpdateKeys[secret]) == 0 &&
+		len(d.DeleteKeys[secret]) > 0
+}
+
+// operations : Returns the list of operations required to reconcile the remote
+// Vault KV with the local state, keys of deleted secrets are grouped together
+func (d *diff) operations() (ops []PlanOperation) {
+	for _, secret := range d.changedSecrets() {
+		for _, key := range d.AddKeys[secret] {
+			ops = append(ops, PlanOperation{Action: "add", Secret: secret, Key: key})
+		}
+		for _, key := range d.UpdateKeys[secret] {
+			ops = append(ops, PlanOperation{Action: "update", Secret: secret, Key: key})
+		}
+		for _, key := range d.DeleteKeys[secret] {
+			ops = append(ops, PlanOperation{Action: "delete", Secret: secret, Key: key})
+		}
+	}
+
+	for _, secret := range sortedKeys(d.UpdateLabels) {
+		ops = append(ops, PlanOperation{Action: "label", Secret: secret, Labels: d.UpdateLabels[secret]})
+	}
+
+	for _, secret := range d.DeleteSecrets {
+		ops = append(ops, PlanOperation{Action: "delete", Secret: secret, Keys: d.DeleteKeys[secret]})
+	}
+
+	return
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeDiff(t *testing.T) {
+	local := map[string]map[string]interface{}{
+		"foo": {"a": "1", "b": "2", "c": "3"},
+		"bar": {"a": "1"},
+	}
+
+	remote := map[string]map[string]interface{}{
+		"foo": {"a": "1", "b": "changed", "d": "4"},
+		"baz": {"a": "1"},
+	}
+
+	d := computeDiff(local, remote)
+	assert.Equal(t, []string{"bar"}, d.AddSecrets)
+	assert.Equal(t, []string{"baz"}, d.DeleteSecrets)
+	assert.Equal(t, map[string][]string{"foo": {"c"}, "bar": {"a"}}, d.AddKeys)
+	assert.Equal(t, map[string][]string{"foo": {"b"}}, d.UpdateKeys)
+	assert.Equal(t, map[string][]string{"foo": {"d"}, "baz": {"a"}}, d.DeleteKeys)
+	assert.Equal(t, []string{"bar", "foo"}, d.changedSecrets())
+	assert.False(t, d.empty())
+}
+
+func TestComputeDiffInSync(t *testing.T) {
+	values := map[string]map[string]interface{}{
+		"foo": {"a": "1"},
+	}
+
+	d := computeDiff(values, values)
+	assert.True(t, d.empty())
+	assert.Empty(t, d.changedSecrets())
+}
+
+func TestDiffOnlyDeletesKeys(t *testing.T) {
+	d := computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": "1"}, "bar": {"a": "1"}, "baz": {}},
+		map[string]map[string]interface{}{"foo": {"a": "1", "b": "2"}, "bar": {"a": "0", "b": "2"}},
+	)
+
+	assert.True(t, d.onlyDeletesKeys("foo"))
+	assert.False(t, d.onlyDeletesKeys("bar"))
+	assert.False(t, d.onlyDeletesKeys("baz"))
+}
+
+func TestDiffIgnoreSecrets(t *testing.T) {
+	d := computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": "1"}, "new": {"a": "1"}},
+		map[string]map[string]interface{}{"bar": {"a": "1"}, "baz": {"a": "1"}},
+	)
+
+	d.ignoreDeletedSecrets(func(secret string) bool { return secret == "baz" })
+	assert.Equal(t, []string{"bar"}, d.DeleteSecrets)
+	assert.Equal(t, map[string][]string{"bar": {"a"}}, d.DeleteKeys)
+
+	d.ignoreAddedSecrets(func(secret string) bool { return secret == "new" })
+	assert.Equal(t, []string{"foo"}, d.AddSecrets)
+	assert.Equal(t, map[string][]string{"foo": {"a"}}, d.AddKeys)
+	assert.Equal(t, []string{"baz", "new"}, d.IgnoredSecrets)
+}
+
+func TestDiffIgnoreKeys(t *testing.T) {
+	d := computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": "1"}, "bar": {"a": "1"}},
+		map[string]map[string]interface{}{"foo": {"b": "1"}, "bar": {"b": "1"}},
+	)
+
+	d.ignoreDeletedKeys(func(secret string) bool { return secret == "foo" })
+	assert.Equal(t, map[string][]string{"bar": {"b"}}, d.DeleteKeys)
+
+	d.ignoreAddedKeys(func(secret string) bool { return secret == "bar" })
+	assert.Equal(t, map[string][]string{"foo": {"a"}}, d.AddKeys)
+}
+
+func TestDiffIgnoreInaccessibleKeys(t *testing.T) {
+	// Inaccessible keys are omitted from the local values
+	d := computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": "1"}, "bar": {}, "new": {}},
+		map[string]map[string]interface{}{"foo": {"a": "0", "b": "1"}, "bar": {"a": "1"}},
+	)
+
+	d.ignoreInaccessibleKeys(map[string][]string{"foo": {"b"}, "bar": {"a"}, "new": {"a"}})
+	assert.Empty(t, d.AddSecrets)
+	assert.Empty(t, d.DeleteSecrets)
+	assert.Empty(t, d.DeleteKeys)
+	assert.Equal(t, map[string][]string{"foo": {"a"}}, d.UpdateKeys)
+	assert.Equal(t, map[string][]string{"foo": {"b"}, "bar": {"a"}, "new": {"a"}}, d.Inaccessible)
+	assert.True(t, d.inaccessible("foo", "b"))
+	assert.False(t, d.inaccessible("foo", "a"))
+}
@@ -0,0 +1,499 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault : In-memory implementation of the parts of the Vault API used by strongbox,
+// served by an httptest server
+type fakeVault struct {
+	mu sync.Mutex
+
+	// TransitKeys are created when used to encrypt a value for the first time
+	TransitKeys map[string]*fakeTransitKey
+
+	// Forbidden lists the transit keys which the token is not allowed to use
+	Forbidden map[string]bool
+
+	// Unreadable lists the transit keys which the token is allowed to use without being
+	// allowed to read their configuration
+	Unreadable map[string]bool
+
+	// Legacy servers reject the partial_failure_response_code parameter and refuse a whole
+	// batch when any of its items fails, without reporting which ones
+	Legacy bool
+
+	// KV holds the secrets of the KV engines, indexed by mount and secret names. A KV v2
+	// engine is mounted at secret/, more can be added alongside their version
+	KV         map[string]map[string]*fakeSecret
+	KVVersions map[string]int
+
+	// PatchStatus, when set, is returned to the PATCH requests in order to emulate servers
+	// which do not support them or tokens lacking the patch capability
+	PatchStatus int
+
+	// Failing lists the KV secrets whose updates fail with an internal error
+	Failing map[string]bool
+
+	// Latency delays every response, outside of the lock, so that concurrent requests overlap
+	Latency time.Duration
+
+	// Requests lists the requests received, as '<METHOD> <path>'
+	Requests []string
+
+	inFlight, maxInFlight int32
+}
+
+// fakeTransitKey : Versions of a transit key of the fake Vault
+type fakeTransitKey struct {
+	LatestVersion        int
+	MinDecryptionVersion int
+}
+
+// fakeSecret : Versions and custom metadata of a secret of the fake Vault, the secrets of
+// the KV v1 engines only have a single version
+type fakeSecret struct {
+	Versions       []*fakeSecretVersion
+	CustomMetadata map[string]interface{}
+}
+
+// fakeSecretVersion : Content of a version of a KV v2 secret of the fake Vault
+type fakeSecretVersion struct {
+	Data      map[string]interface{}
+	Deleted   bool
+	Destroyed bool
+}
+
+// current : Returns the current version of the secret
+func (fs *fakeSecret) current() int {
+	return len(fs.Versions)
+}
+
+// data : Returns the content of the current version, nil once deleted or destroyed
+func (fs *fakeSecret) data() map[string]interface{} {
+	if len(fs.Versions) == 0 {
+		return nil
+	}
+
+	latest := fs.Versions[len(fs.Versions)-1]
+	if latest.Deleted || latest.Destroyed {
+		return nil
+	}
+	return latest.Data
+}
+
+// newFakeVault : Starts a fake Vault and configures the Vault client to use it
+func newFakeVault(t *testing.T) *fakeVault {
+	f := &fakeVault{
+		TransitKeys: make(map[string]*fakeTransitKey),
+		Forbidden:   make(map[string]bool),
+		Unreadable:  make(map[string]bool),
+		KV:          map[string]map[string]*fakeSecret{"secret": {}},
+		KVVersions:  map[string]int{"secret": 2},
+		Failing:     make(map[string]bool),
+	}
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	config := api.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+	c, err := api.NewClient(config)
+	require.NoError(t, err)
+	c.SetToken("test")
+
+	previous := v
+	v = &Vault{c}
+	t.Cleanup(func() { v = previous })
+	return f
+}
+
+// MaxInFlight : Returns the maximum amount of requests which have been served concurrently
+func (f *fakeVault) MaxInFlight() int {
+	return int(atomic.LoadInt32(&f.maxInFlight))
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	current := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+	for max := atomic.LoadInt32(&f.maxInFlight); current > max; max = atomic.LoadInt32(&f.maxInFlight) {
+		if atomic.CompareAndSwapInt32(&f.maxInFlight, max, current) {
+			break
+		}
+	}
+	time.Sleep(f.Latency)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	f.Requests = append(f.Requests, r.Method+" "+path)
+
+	var body map[string]interface{}
+	if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "transit" && parts[1] == "keys":
+		f.serveTransitKey(w, r.Method, parts[2], strings.Join(parts[3:], "/"), body)
+	case len(parts) == 3 && parts[0] == "transit":
+		f.serveTransit(w, parts[1], parts[2], body)
+	case f.KV[parts[0]] != nil:
+		method := r.Method
+		if method == http.MethodGet && r.URL.Query().Get("list") == "true" {
+			method = "LIST"
+		}
+
+		// The trailing slash of the folders is stripped by the client
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+
+		if f.KVVersions[parts[0]] == 2 {
+			f.serveKV2(w, method, parts[0], parts[1], strings.Join(parts[2:], "/"), body)
+		} else {
+			f.serveKV1(w, method, parts[0], strings.Join(parts[1:], "/"), body)
+		}
+	default:
+		fakeError(w, http.StatusNotFound, "no handler for route '"+path+"'")
+	}
+}
+
+// serveTransitKey : Handles the reads, rotations and configuration of the transit keys
+func (f *fakeVault) serveTransitKey(w http.ResponseWriter, method, key, action string, body map[string]interface{}) {
+	if f.Forbidden[key] || (f.Unreadable[key] && action == "" && method == http.MethodGet) {
+		fakeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	k := f.TransitKeys[key]
+	if k == nil {
+		fakeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	switch {
+	case action == "" && method == http.MethodGet:
+		fakeRespond(w, http.StatusOK, map[string]interface{}{
+			"latest_version":         k.LatestVersion,
+			"min_decryption_version": k.MinDecryptionVersion,
+		})
+	case action == "rotate":
+		k.LatestVersion++
+		w.WriteHeader(http.StatusNoContent)
+	case action == "config":
+		if version, ok := body["min_decryption_version"].(float64); ok {
+			k.MinDecryptionVersion = int(version)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+// serveTransit : Handles the encrypt, decrypt and rewrap batch operations
+func (f *fakeVault) serveTransit(w http.ResponseWriter, operation, key string, body map[string]interface{}) {
+	if f.Forbidden[key] {
+		fakeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	if f.TransitKeys[key] == nil {
+		if operation != transitEncrypt {
+			fakeError(w, http.StatusBadRequest, "encryption key not found")
+			return
+		}
+		f.TransitKeys[key] = &fakeTransitKey{LatestVersion: 1, MinDecryptionVersion: 1}
+	}
+
+	partialFailureCode, partialFailure := body["partial_failure_response_code"].(float64)
+	if partialFailure && f.Legacy {
+		fakeError(w, http.StatusBadRequest, "unknown field partial_failure_response_code")
+		return
+	}
+
+	inputs, _ := body["batch_input"].([]interface{})
+	results := make([]interface{}, len(inputs))
+	failures := 0
+	for i, input := range inputs {
+		item, _ := input.(map[string]interface{})
+		result, err := f.transitItem(operation, key, item)
+		if err != nil {
+			result = map[string]interface{}{"error": err.Error()}
+			failures++
+		}
+		results[i] = result
+	}
+
+	switch {
+	case failures > 0 && f.Legacy:
+		fakeError(w, http.StatusBadRequest, "one or more items failed")
+	case failures == len(inputs):
+		fakeRespond(w, http.StatusBadRequest, map[string]interface{}{"batch_results": results})
+	case failures > 0 && partialFailure:
+		fakeRespond(w, int(partialFailureCode), map[string]interface{}{"batch_results": results})
+	case failures > 0:
+		fakeRespond(w, http.StatusBadRequest, map[string]interface{}{"batch_results": results})
+	default:
+		fakeRespond(w, http.StatusOK, map[string]interface{}{"batch_results": results})
+	}
+}
+
+// transitItem : Runs a transit operation onto an item of a batch
+func (f *fakeVault) transitItem(operation, key string, item map[string]interface{}) (map[string]interface{}, error) {
+	k := f.TransitKeys[key]
+	if operation == transitEncrypt {
+		plaintext, _ := item["plaintext"].(string)
+		return map[string]interface{}{"ciphertext": fakeCiphertext(key, k.LatestVersion, plaintext)}, nil
+	}
+
+	ciphertext, _ := item["ciphertext"].(string)
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return nil, fmt.Errorf("invalid ciphertext: no prefix")
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	fields := strings.SplitN(string(data), "|", 2)
+	if len(fields) != 2 || fields[0] != key || version > k.LatestVersion {
+		return nil, fmt.Errorf("cipher: message authentication failed")
+	}
+
+	if version < k.MinDecryptionVersion {
+		return nil, fmt.Errorf("ciphertext version is disallowed by policy (too old)")
+	}
+
+	if operation == transitRewrap {
+		return map[string]interface{}{"ciphertext": fakeCiphertext(key, k.LatestVersion, fields[1])}, nil
+	}
+	return map[string]interface{}{"plaintext": fields[1]}, nil
+}
+
+// fakeCiphertext : Ciphertexts of the fake Vault are bound to their transit key and version
+func fakeCiphertext(key string, version int, plaintext string) string {
+	return fmt.Sprintf("vault:v%d:%v", version, base64.StdEncoding.EncodeToString([]byte(key+"|"+plaintext)))
+}
+
+// serveKV1 : Handles the secrets of a KV v1 engine
+func (f *fakeVault) serveKV1(w http.ResponseWriter, method, mount, name string, body map[string]interface{}) {
+	if method == "LIST" {
+		f.listKV(w, mount, name)
+		return
+	}
+
+	if f.Failing[name] && method != http.MethodGet {
+		fakeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	secret := f.KV[mount][name]
+	switch method {
+	case http.MethodGet:
+		if secret == nil {
+			fakeError(w, http.StatusNotFound, "")
+			return
+		}
+		fakeRespond(w, http.StatusOK, secret.data())
+	case http.MethodPut, http.MethodPost:
+		f.KV[mount][name] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: body}}}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(f.KV[mount], name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+// serveKV2 : Handles the data, metadata and destroy endpoints of a KV v2 engine
+func (f *fakeVault) serveKV2(w http.ResponseWriter, method, mount, endpoint, name string, body map[string]interface{}) {
+	if method == "LIST" {
+		if endpoint != "metadata" {
+			fakeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+			return
+		}
+		f.listKV(w, mount, name)
+		return
+	}
+
+	if f.Failing[name] && method != http.MethodGet {
+		fakeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	secret := f.KV[mount][name]
+	switch endpoint + " " + method {
+	case "data GET":
+		if secret == nil {
+			fakeError(w, http.StatusNotFound, "")
+			return
+		}
+
+		metadata := map[string]interface{}{"version": secret.current(), "custom_metadata": secret.CustomMetadata}
+		if secret.data() == nil {
+			fakeRespond(w, http.StatusNotFound, map[string]interface{}{"data": nil, "metadata": metadata})
+			return
+		}
+		fakeRespond(w, http.StatusOK, map[string]interface{}{"data": secret.data(), "metadata": metadata})
+	case "data PUT", "data POST":
+		if secret == nil {
+			secret = &fakeSecret{}
+		}
+		if !fakeCAS(w, secret, body) {
+			return
+		}
+
+		data, _ := body["data"].(map[string]interface{})
+		secret.Versions = append(secret.Versions, &fakeSecretVersion{Data: data})
+		f.KV[mount][name] = secret
+		fakeRespond(w, http.StatusOK, map[string]interface{}{"version": secret.current()})
+	case "data PATCH":
+		if f.PatchStatus != 0 {
+			fakeError(w, f.PatchStatus, "patch is not available")
+			return
+		}
+		if secret == nil || secret.data() == nil {
+			fakeError(w, http.StatusNotFound, "")
+			return
+		}
+		if !fakeCAS(w, secret, body) {
+			return
+		}
+
+		data := make(map[string]interface{})
+		for key, value := range secret.data() {
+			data[key] = value
+		}
+		patch, _ := body["data"].(map[string]interface{})
+		for key, value := range patch {
+			if value == nil {
+				delete(data, key)
+			} else {
+				data[key] = value
+			}
+		}
+		secret.Versions = append(secret.Versions, &fakeSecretVersion{Data: data})
+		fakeRespond(w, http.StatusOK, map[string]interface{}{"version": secret.current()})
+	case "data DELETE":
+		if secret != nil && secret.current() > 0 {
+			secret.Versions[secret.current()-1].Deleted = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "metadata GET":
+		if secret == nil {
+			fakeError(w, http.StatusNotFound, "")
+			return
+		}
+
+		versions := make(map[string]interface{})
+		for i, version := range secret.Versions {
+			versions[strconv.Itoa(i+1)] = map[string]interface{}{"destroyed": version.Destroyed}
+		}
+		fakeRespond(w, http.StatusOK, map[string]interface{}{
+			"current_version": secret.current(),
+			"custom_metadata": secret.CustomMetadata,
+			"versions":        versions,
+		})
+	case "metadata PUT", "metadata POST":
+		if secret == nil {
+			secret = &fakeSecret{}
+			f.KV[mount][name] = secret
+		}
+		secret.CustomMetadata, _ = body["custom_metadata"].(map[string]interface{})
+		w.WriteHeader(http.StatusNoContent)
+	case "metadata DELETE":
+		delete(f.KV[mount], name)
+		w.WriteHeader(http.StatusNoContent)
+	case "destroy PUT", "destroy POST":
+		versions, _ := body["versions"].([]interface{})
+		for _, version := range versions {
+			if i, ok := version.(float64); ok && secret != nil && int(i) >= 1 && int(i) <= secret.current() {
+				secret.Versions[int(i)-1].Destroyed = true
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+// listKV : Lists the secrets and the sub-folders of a folder of a KV engine
+func (f *fakeVault) listKV(w http.ResponseWriter, mount, folder string) {
+	if folder != "" && !strings.HasSuffix(folder, "/") {
+		folder += "/"
+	}
+
+	found := make(map[string]bool)
+	var keys []interface{}
+	for name := range f.KV[mount] {
+		if !strings.HasPrefix(name, folder) {
+			continue
+		}
+
+		key := strings.TrimPrefix(name, folder)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !found[key] {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		fakeError(w, http.StatusNotFound, "")
+		return
+	}
+	fakeRespond(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// fakeCAS : Refuses the write if its check-and-set version does not match the current one
+func fakeCAS(w http.ResponseWriter, secret *fakeSecret, body map[string]interface{}) bool {
+	options, _ := body["options"].(map[string]interface{})
+	if cas, ok := options["cas"].(float64); ok && int(cas) != secret.current() {
+		fakeError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return false
+	}
+	return true
+}
+
+func fakeRespond(w http.ResponseWriter, code int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func fakeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{message}})
+}
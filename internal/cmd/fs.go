@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lockFileExtension : Extension of the file used to lock a statefile, it lives next to it
+const lockFileExtension = ".lock"
+
+// lockMode : Kind of lock held onto a statefile
+type lockMode int
+
+const (
+	// lockShared : the statefile is only read, several processes can hold it at once
+	lockShared lockMode = iota
+
+	// lockExclusive : the statefile is updated, no other process can hold it meanwhile
+	lockExclusive
+)
+
+// errLocked : Returned when trying to lock a file which is already locked by another process
+var errLocked = errors.New("already locked")
+
+// stateLock : Lock of a statefile held by this process, the file is nil when the lock
+// file could not be created on a read-only filesystem
+type stateLock struct {
+	file *os.File
+	mode lockMode
+}
+
+var (
+	// stateLocks holds the locks acquired by this process, indexed by the absolute paths
+	// of the statefiles they protect
+	stateLocks   = make(map[string]*stateLock)
+	stateLocksMu sync.Mutex
+)
+
+// lockState : Acquires the advisory lock of a statefile, it is held until unlockStates
+// gets called. Acquiring it again from the same process is a no-op, a shared lock cannot
+// be upgraded to an exclusive one
+func lockState(path string, mode lockMode) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	stateLocksMu.Lock()
+	defer stateLocksMu.Unlock()
+	if l, found := stateLocks[path]; found {
+		if l.mode == lockExclusive || mode == lockShared {
+			return nil
+		}
+
+		// Releasing the shared lock in order to acquire the exclusive one would let another
+		// process update the statefile in between, overwriting what it wrote
+		return fmt.Errorf("state file %v has been loaded read-only, it cannot be updated by this command", path)
+	}
+
+	lockPath := path + lockFileExtension
+	for {
+		f, err := openLockFile(lockPath, mode)
+		if err != nil {
+			// Nothing can update a statefile stored on a read-only filesystem, it can
+			// safely be read without being locked
+			if mode == lockShared && (errors.Is(err, syscall.EROFS) || os.IsPermission(err)) {
+				log.Debugf("Unable to create the lock file %v, reading the state file without locking it: %v", lockPath, err)
+				stateLocks[path] = &stateLock{mode: mode}
+				return nil
+			}
+			return err
+		}
+
+		if err = lockFile(f, mode); err != nil {
+			_ = f.Close()
+			if err == errLocked {
+				return lockedStateError(path, lockPath)
+			}
+			return err
+		}
+
+		// The lock file may have been removed by its previous holder while we were
+		// waiting for it, in which case we have to lock the new one instead
+		if info, err := os.Stat(lockPath); err != nil || !sameFile(f, info) {
+			_ = f.Close()
+			continue
+		}
+
+		// Only the process updating the statefile records itself as its holder
+		if mode == lockExclusive {
+			if err = f.Truncate(0); err == nil {
+				_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			}
+
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+
+		stateLocks[path] = &stateLock{file: f, mode: mode}
+		return nil
+	}
+}
+
+// openLockFile : Opens the lock file of a statefile, creating it if needed. An existing
+// lock file is opened read-only when it is only used to acquire a shared lock
+func openLockFile(lockPath string, mode lockMode) (*os.File, error) {
+	if mode == lockShared {
+		if f, err := os.Open(filepath.Clean(lockPath)); !os.IsNotExist(err) {
+			return f, err
+		}
+	}
+	return os.OpenFile(filepath.Clean(lockPath), os.O_RDWR|os.O_CREATE, 0o600)
+}
+
+// lockedStateError : Describes the process holding the lock of a statefile, the lock file
+// of a process which crashed is not an issue as its lock is released by the system
+func lockedStateError(path, lockPath string) error {
+	holder := "another strongbox process"
+	if data, err := ioutil.ReadFile(filepath.Clean(lockPath)); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		holder = fmt.Sprintf("%v (pid %v)", holder, strings.TrimSpace(string(data)))
+	}
+	return fmt.Errorf("state file %v is locked by %v, retry once it has completed", path, holder)
+}
+
+// sameFile : Returns true if the opened file is the one described by info
+func sameFile(f *os.File, info os.FileInfo) bool {
+	current, err := f.Stat()
+	return err == nil && os.SameFile(current, info)
+}
+
+// unlockStates : Releases the locks of the statefiles acquired by this process
+func unlockStates() {
+	stateLocksMu.Lock()
+	defer stateLocksMu.Unlock()
+	for path, l := range stateLocks {
+		releaseStateLock(path, l)
+		delete(stateLocks, path)
+	}
+}
+
+// releaseStateLock : Releases the lock of a statefile and removes its lock file, unless
+// another process still holds it
+func releaseStateLock(path string, l *stateLock) {
+	if l.file == nil {
+		return
+	}
+
+	lockPath := path + lockFileExtension
+	if l.mode == lockShared {
+		// The lock file is only removed by the last process reading the statefile, which
+		// is the one able to acquire it exclusively once its shared lock is released
+		_ = l.file.Close()
+		f, err := os.Open(filepath.Clean(lockPath))
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		if lockFile(f, lockExclusive) != nil {
+			return
+		}
+
+		if info, err := os.Stat(lockPath); err != nil || !sameFile(f, info) {
+			return
+		}
+		removeLockFile(lockPath)
+		return
+	}
+
+	// Removing the file before releasing the lock ensures that a process waiting
+	// for it notices that it has to create a new one
+	removeLockFile(lockPath)
+	_ = l.file.Close()
+}
+
+// writeFileAtomic : Writes data to a file by writing a temporary file next to it and
+// renaming it over the original one, so that it never ends up partially written
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
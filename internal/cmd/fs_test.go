@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir(tmpDir, "atomic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("foo"), 0o644))
+	require.NoError(t, writeFileAtomic(path, []byte("bar"), 0o600))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(data))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, os.FileMode(0o600), files[0].Mode().Perm())
+}
+
+func TestLockState(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	defer unlockStates()
+
+	// Locks are held for the whole process
+	require.NoError(t, lockState(s.Config.Path, lockExclusive))
+	s.Load()
+
+	lockPath, err := filepath.Abs(s.Config.Path + lockFileExtension)
+	require.NoError(t, err)
+	f, err := os.OpenFile(lockPath, os.O_RDWR, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, errLocked, lockFile(f, lockShared))
+
+	err = lockedStateError(s.Config.Path, lockPath)
+	assert.Contains(t, err.Error(), "is locked by another strongbox process (pid ")
+
+	unlockStates()
+	assert.NoFileExists(t, lockPath)
+	require.NoError(t, lockState(s.Config.Path, lockExclusive))
+	assert.FileExists(t, lockPath)
+}
+
+func TestLockStateShared(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	unlockStates()
+	defer unlockStates()
+
+	lockPath, err := filepath.Abs(s.Config.Path + lockFileExtension)
+	require.NoError(t, err)
+
+	// Reading the statefile does not prevent other processes from reading it
+	s.Load()
+	f, err := os.Open(lockPath)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, lockFile(f, lockShared))
+
+	// The lock file is left in place as long as another process is reading the statefile
+	unlockStates()
+	assert.FileExists(t, lockPath)
+
+	// A shared lock is never upgraded, another process could update the statefile while
+	// it is released
+	s.Load()
+	err = lockState(s.Config.Path, lockExclusive)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has been loaded read-only")
+
+	// Locking it exclusively fails while another process is reading it
+	unlockStates()
+	err = lockState(s.Config.Path, lockExclusive)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is locked by another strongbox process")
+
+	require.NoError(t, f.Close())
+	require.NoError(t, lockState(s.Config.Path, lockExclusive))
+	data, err := ioutil.ReadFile(lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(data))
+
+	unlockStates()
+	s.Load()
+	unlockStates()
+	assert.NoFileExists(t, lockPath)
+}
+
+func TestLockStateReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+
+	dir, err := ioutil.TempDir(tmpDir, "readonly")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("version: 4\n"), 0o600))
+	require.NoError(t, os.Chmod(dir, 0o500))
+	defer func() { _ = os.Chmod(dir, 0o700) }()
+	defer unlockStates()
+
+	// The statefile can still be read, but not updated
+	require.NoError(t, lockState(path, lockShared))
+	assert.NoFileExists(t, path+lockFileExtension)
+	assert.Error(t, lockState(path, lockExclusive))
+}
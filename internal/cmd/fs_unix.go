@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile : Acquires a shared or exclusive advisory lock on an opened file, without waiting
+func lockFile(f *os.File, mode lockMode) error {
+	how := unix.LOCK_SH
+	if mode == lockExclusive {
+		how = unix.LOCK_EX
+	}
+
+	if err := unix.Flock(int(f.Fd()), how|unix.LOCK_NB); err != nil {
+		if err == unix.EWOULDBLOCK {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// removeLockFile : Removes a lock file while it is still locked, processes which opened it
+// meanwhile notice it is gone once they acquire it
+func removeLockFile(lockPath string) {
+	_ = os.Remove(lockPath)
+}
+
+// syncDir : Flushes a directory, so that the files renamed into it persist a crash
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile : Acquires a shared or exclusive lock on an opened file, without waiting
+func lockFile(f *os.File, mode lockMode) error {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if mode == lockExclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// removeLockFile : Files cannot be removed while they are opened on windows, the lock file
+// is left in place and reused by the next process
+func removeLockFile(_ string) {}
+
+// syncDir : Directories cannot be flushed on windows, renames are durable once they
+// have returned
+func syncDir(_ string) error {
+	return nil
+}
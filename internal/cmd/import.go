@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+	cli "github.com/urfave/cli/v2"
+)
+
+// ImportResult : Secrets imported from Vault into the statefile
+type ImportResult struct {
+	Imported map[string]int `json:"imported" yaml:"imported"`
+	Skipped  []string       `json:"skipped" yaml:"skipped"`
+}
+
+func (r *ImportResult) renderTable(w io.Writer) {
+	for _, secret := range sortedKeys(r.Imported) {
+		color.New(color.FgGreen).Fprintf(w, "=> Imported secret '%v' (%v key(s))\n", secret, r.Imported[secret])
+	}
+
+	for _, secret := range r.Skipped {
+		color.New(color.FgYellow).Fprintf(w, "=> Skipped secret '%v', it already exists in the statefile\n", secret)
+	}
+
+	fmt.Fprintf(w, "Imported %v secret(s), skipped %v\n", len(r.Imported), len(r.Skipped))
+}
+
+// Import ..
+func Import(ctx *cli.Context) (int, error) {
+	if ctx.Bool("all") == (ctx.NArg() > 0) {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, fmt.Errorf("either --all or a list of secrets must be provided")
+	}
+
+	s.LoadForUpdate()
+
+	secrets := ctx.Args().Slice()
+	if ctx.Bool("all") {
+		var err error
+		if secrets, err = listRemoteSecrets(); err != nil {
+			return 1, err
+		}
+	}
+
+	r := &ImportResult{
+		Imported: make(map[string]int),
+		Skipped:  []string{},
+	}
+	var candidates []string
+	for _, secret := range secrets {
+		if ctx.Bool("all") && s.isIgnored(secret) {
+			continue
+		}
+
+		if _, exists := s.target().Secrets[secret]; exists && !ctx.Bool("overwrite") {
+			r.Skipped = append(r.Skipped, secret)
+			continue
+		}
+		candidates = append(candidates, secret)
+	}
+
+	// The secrets are read concurrently, a secret which cannot be read does not prevent
+	// the other ones from being read but nothing gets imported
+	var mu sync.Mutex
+	imported := make(map[string]map[string]interface{})
+	errs := forEachParallel(candidates, func(secret string) error {
+		values, err := readRemoteSecret(secret)
+		if err != nil {
+			return err
+		}
+
+		if values == nil {
+			if ctx.Bool("all") {
+				return nil
+			}
+			return fmt.Errorf("secret not found in Vault at %v", s.VaultKVPath())
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		imported[secret] = values
+		return nil
+	})
+
+	if err := kvError("import", errs); err != nil {
+		return 1, err
+	}
+
+	// The values of all the secrets are ciphered at once, in batches
+	keys, err := cipherValues(imported)
+	if err != nil {
+		return 1, err
+	}
+
+	// The statefile is saved once, rather than for each of the imported secrets
+	for _, secret := range sortedKeys(imported) {
+		if keys[secret] == nil {
+			keys[secret] = make(map[string]SecretKey)
+		}
+		r.Imported[secret] = len(keys[secret])
+	}
+
+	if len(keys) > 0 {
+		s.SetSecrets(keys)
+	}
+
+	if err := render(r); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
@@ -2,15 +2,46 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/urfave/cli/v2"
 )
 
+// KVPathResult : Configured Vault KV path
+type KVPathResult struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+func (r KVPathResult) renderTable(w io.Writer) {
+	fmt.Fprintln(w, r.Path)
+}
+
+// KVVersionResult : Configured Vault KV version
+type KVVersionResult struct {
+	Version int `json:"version" yaml:"version"`
+}
+
+func (r KVVersionResult) renderTable(w io.Writer) {
+	fmt.Fprintln(w, r.Version)
+}
+
+// KVDeleteModeResult : Configured Vault KV v2 deletion mode
+type KVDeleteModeResult struct {
+	DeleteMode  string `json:"delete_mode" yaml:"delete_mode"`
+	Description string `json:"description" yaml:"description"`
+}
+
+func (r KVDeleteModeResult) renderTable(w io.Writer) {
+	fmt.Fprintf(w, "%v (%v)\n", r.DeleteMode, r.Description)
+}
+
 // KVGetPath ..
 func KVGetPath(_ *cli.Context) (int, error) {
 	s.Load()
-	fmt.Println(s.VaultKVPath())
+	if err := render(KVPathResult{Path: s.VaultKVPath()}); err != nil {
+		return 1, err
+	}
 
 	return 0, nil
 }
@@ -23,7 +54,7 @@ func KVSetPath(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
+	s.LoadForUpdate()
 	s.SetVaultKVPath(ctx.Args().First())
 
 	return 0, nil
@@ -32,7 +63,9 @@ func KVSetPath(ctx *cli.Context) (int, error) {
 // KVGetVersion ..
 func KVGetVersion(_ *cli.Context) (int, error) {
 	s.Load()
-	fmt.Println(s.VaultKVVersion())
+	if err := render(KVVersionResult{Version: s.VaultKVVersion()}); err != nil {
+		return 1, err
+	}
 
 	return 0, nil
 }
@@ -45,7 +78,7 @@ func KVSetVersion(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
+	s.LoadForUpdate()
 
 	version, err := strconv.Atoi(ctx.Args().First())
 	if err != nil {
@@ -60,3 +93,203 @@ func KVSetVersion(ctx *cli.Context) (int, error) {
 
 	return 0, nil
 }
+
+// KVGetDeleteMode ..
+func KVGetDeleteMode(_ *cli.Context) (int, error) {
+	s.Load()
+	if err := render(KVDeleteModeResult{
+		DeleteMode:  s.VaultKVDeleteMode(),
+		Description: deleteModeDescriptions[s.VaultKVDeleteMode()],
+	}); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVSetDeleteMode ..
+func KVSetDeleteMode(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := validateDeleteMode(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	s.SetVaultKVDeleteMode(ctx.Args().First())
+
+	return 0, nil
+}
+
+// KVOwnershipResult : Configured ownership of the secrets stored in the Vault KV
+type KVOwnershipResult struct {
+	Ownership string   `json:"ownership" yaml:"ownership"`
+	Ignore    []string `json:"ignore" yaml:"ignore"`
+	Merge     []string `json:"merge" yaml:"merge"`
+}
+
+func (r KVOwnershipResult) renderTable(w io.Writer) {
+	fmt.Fprintln(w, r.Ownership)
+	for _, pattern := range r.Ignore {
+		fmt.Fprintf(w, "ignore: %v\n", pattern)
+	}
+	for _, pattern := range r.Merge {
+		fmt.Fprintf(w, "merge: %v\n", pattern)
+	}
+}
+
+// KVGetOwnership ..
+func KVGetOwnership(_ *cli.Context) (int, error) {
+	s.Load()
+	if err := render(KVOwnershipResult{
+		Ownership: s.VaultKVOwnership(),
+		Ignore:    append([]string{}, s.VaultKVIgnore()...),
+		Merge:     append([]string{}, s.VaultKVMerge()...),
+	}); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVSetOwnership ..
+func KVSetOwnership(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	switch ctx.Args().First() {
+	case ownershipAll:
+	case ownershipMarked:
+		if s.VaultKVVersion() != 2 {
+			return 1, fmt.Errorf("the '%v' ownership mode requires KV v2", ownershipMarked)
+		}
+	default:
+		return 1, fmt.Errorf("ownership must be either %v or %v, got '%v'", ownershipAll, ownershipMarked, ctx.Args().First())
+	}
+
+	s.SetVaultKVOwnership(ctx.Args().First())
+
+	return 0, nil
+}
+
+// KVIgnore ..
+func KVIgnore(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.AddVaultKVIgnore(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVUnignore ..
+func KVUnignore(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.RemoveVaultKVIgnore(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVMerge ..
+func KVMerge(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.AddVaultKVMerge(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVUnmerge ..
+func KVUnmerge(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.RemoveVaultKVMerge(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVSyncLabelsResult : Whether the labels of the secrets get written into their KV v2
+// custom metadata
+type KVSyncLabelsResult struct {
+	SyncLabels bool `json:"sync_labels" yaml:"sync_labels"`
+}
+
+func (r KVSyncLabelsResult) renderTable(w io.Writer) {
+	fmt.Fprintln(w, r.SyncLabels)
+}
+
+// KVGetSyncLabels ..
+func KVGetSyncLabels(_ *cli.Context) (int, error) {
+	s.Load()
+	if err := render(KVSyncLabelsResult{SyncLabels: s.VaultKVSyncLabels()}); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// KVSetSyncLabels ..
+func KVSetSyncLabels(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+
+	sync, err := strconv.ParseBool(ctx.Args().First())
+	if err != nil {
+		return 1, fmt.Errorf("expected true or false, got '%v'", ctx.Args().First())
+	}
+
+	s.LoadForUpdate()
+	if sync && s.VaultKVVersion() != 2 {
+		return 1, fmt.Errorf("labels can only be synchronized with KV v2")
+	}
+
+	s.SetVaultKVSyncLabels(sync)
+
+	return 0, nil
+}
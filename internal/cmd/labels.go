@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.\-/]*[A-Za-z0-9])?$`)
+	labelValueRegexp = regexp.MustCompile(`^[A-Za-z0-9_.\-/]*$`)
+)
+
+// validateLabel : Ensures that a label can be used within a selector
+func validateLabel(key, value string) error {
+	if !labelKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid label key '%v', it must be made of alphanumeric characters, '-', '_', '.' or '/'", key)
+	}
+
+	if !labelValueRegexp.MatchString(value) {
+		return fmt.Errorf("invalid value '%v' for label '%v', it must be made of alphanumeric characters, '-', '_', '.' or '/'", value, key)
+	}
+	return nil
+}
+
+// Label selector operators
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+// labelRequirement : Condition on a label of the secrets
+type labelRequirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// selector : Comma separated list of requirements on the labels of the secrets, all of
+// them have to be met (eg: team=payments,env!=dev,tier,!deprecated)
+type selector []labelRequirement
+
+// parseSelector : Parses a selector expression, an empty one matches every secret
+func parseSelector(expr string) (sel selector, err error) {
+	if strings.TrimSpace(expr) == "" {
+		return
+	}
+
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		var r labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = labelRequirement{Key: kv[0], Operator: selectorNotEquals, Value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			r = labelRequirement{Key: kv[0], Operator: selectorEquals, Value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			r = labelRequirement{Key: strings.TrimPrefix(part, "!"), Operator: selectorNotExists}
+		default:
+			r = labelRequirement{Key: part, Operator: selectorExists}
+		}
+
+		r.Key, r.Value = strings.TrimSpace(r.Key), strings.TrimSpace(r.Value)
+		if err = validateLabel(r.Key, r.Value); err != nil {
+			return nil, fmt.Errorf("invalid selector '%v': %v", expr, err)
+		}
+		sel = append(sel, r)
+	}
+	return
+}
+
+// matches : Returns true if the labels meet all the requirements of the selector
+func (sel selector) matches(labels map[string]string) bool {
+	for _, r := range sel {
+		value, found := labels[r.Key]
+		switch r.Operator {
+		case selectorEquals:
+			if !found || value != r.Value {
+				return false
+			}
+		case selectorNotEquals:
+			if found && value == r.Value {
+				return false
+			}
+		case selectorExists:
+			if !found {
+				return false
+			}
+		case selectorNotExists:
+			if found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// selectorFromContext : Parses the --selector flag of a command
+func selectorFromContext(ctx *cli.Context) (selector, error) {
+	return parseSelector(ctx.String("selector"))
+}
+
+// selectedBy : Returns a function telling whether a secret of the statefile matches the
+// selector, secrets which are not defined in the statefile never do when it is not empty
+func (s *State) selectedBy(sel selector) func(secret string) bool {
+	return func(secret string) bool {
+		if len(sel) == 0 {
+			return true
+		}
+
+		if _, found := s.target().Secrets[secret]; !found {
+			return false
+		}
+		return sel.matches(s.SecretLabels(secret))
+	}
+}
+
+// SecretLabels : Returns the labels of a secret
+func (s *State) SecretLabels(secret string) map[string]string {
+	return s.target().Labels[secret]
+}
+
+// LabelSecret : Updates the labels of a secret, using key=value to set a label and
+// key- to remove it
+func (s *State) LabelSecret(secret string, changes []string) error {
+	t := s.target()
+	if t.Secrets[secret] == nil {
+		return fmt.Errorf("no secret '%v' found", secret)
+	}
+
+	labels := make(map[string]string)
+	for key, value := range t.Labels[secret] {
+		labels[key] = value
+	}
+
+	for _, change := range changes {
+		if strings.HasSuffix(change, "-") && !strings.Contains(change, "=") {
+			delete(labels, strings.TrimSuffix(change, "-"))
+			continue
+		}
+
+		kv := strings.SplitN(change, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid label '%v', expected <key>=<value> or <key>-", change)
+		}
+
+		if err := validateLabel(kv[0], kv[1]); err != nil {
+			return err
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	if t.Labels == nil {
+		t.Labels = make(map[string]map[string]string)
+	}
+
+	if len(labels) == 0 {
+		delete(t.Labels, secret)
+	} else {
+		t.Labels[secret] = labels
+	}
+
+	s.save()
+	return nil
+}
+
+// formatLabels : Returns the key=value representation of some labels, sorted by keys
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// SecretLabel ..
+func SecretLabel(ctx *cli.Context) (int, error) {
+	if ctx.String("secret") == "" || ctx.NArg() == 0 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.LabelSecret(ctx.String("secret"), ctx.Args().Slice()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
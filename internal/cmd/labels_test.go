@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	sel, err := parseSelector("team=payments, env!=dev,tier,!deprecated,region==eu")
+	require.NoError(t, err)
+	assert.Equal(t, selector{
+		{Key: "team", Operator: selectorEquals, Value: "payments"},
+		{Key: "env", Operator: selectorNotEquals, Value: "dev"},
+		{Key: "tier", Operator: selectorExists},
+		{Key: "deprecated", Operator: selectorNotExists},
+		{Key: "region", Operator: selectorEquals, Value: "eu"},
+	}, sel)
+
+	sel, err = parseSelector("")
+	require.NoError(t, err)
+	assert.Empty(t, sel)
+
+	_, err = parseSelector("team=pay ments")
+	assert.Error(t, err)
+
+	_, err = parseSelector("=foo")
+	assert.Error(t, err)
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	for expr, expected := range map[string]bool{
+		"":                   true,
+		"team=payments":      true,
+		"team=search":        false,
+		"env!=dev":           true,
+		"env!=prod":          false,
+		"tier!=critical":     true,
+		"team":               true,
+		"tier":               false,
+		"!tier":              true,
+		"team=payments,tier": false,
+	} {
+		sel, err := parseSelector(expr)
+		require.NoError(t, err)
+		assert.Equal(t, expected, sel.matches(labels), expr)
+	}
+}
+
+func TestStateLabelSecret(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive"})
+	s.WriteSecretKey("baz", "bar", SecretKey{Value: "sensitive"})
+
+	require.NoError(t, s.LabelSecret("foo", []string{"team=payments", "env=prod"}))
+	assert.Error(t, s.LabelSecret("unknown", []string{"team=payments"}))
+	assert.Error(t, s.LabelSecret("foo", []string{"team"}))
+	assert.Error(t, s.LabelSecret("foo", []string{"team=pay ments"}))
+	s.Load()
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, s.SecretLabels("foo"))
+
+	require.NoError(t, s.LabelSecret("foo", []string{"env-"}))
+	assert.Equal(t, map[string]string{"team": "payments"}, s.SecretLabels("foo"))
+
+	sel, err := parseSelector("team=payments")
+	require.NoError(t, err)
+	assert.True(t, s.selectedBy(sel)("foo"))
+	assert.False(t, s.selectedBy(sel)("baz"))
+	assert.False(t, s.selectedBy(sel)("unknown"))
+	assert.True(t, s.selectedBy(nil)("unknown"))
+
+	r, err := s.ListSecrets("", sel)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, sortedKeys(r.Secrets))
+
+	require.NoError(t, s.LabelSecret("foo", []string{"team-"}))
+	assert.NotContains(t, s.target().Labels, "foo")
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Layouts of the statefile
+const (
+	// layoutFile : the whole state is stored into a single file
+	layoutFile = "file"
+
+	// layoutDirectory : the configuration is stored into an index file and each secret
+	// into its own file, underneath a directory
+	layoutDirectory = "directory"
+)
+
+const (
+	// stateIndexFile : Name of the file holding the configuration of the targets when
+	// using the directory layout
+	stateIndexFile = "state.yml"
+
+	// secretFileExtension : Extension of the files holding the secrets when using the
+	// directory layout
+	secretFileExtension = ".yml"
+)
+
+// secretFile : Content of the file of a secret when using the directory layout
+type secretFile struct {
+	Labels map[string]string    `yaml:"labels,omitempty"`
+	Keys   map[string]SecretKey `yaml:"keys"`
+}
+
+// validateLayout : Ensures that a layout is supported
+func validateLayout(layout string) error {
+	if layout != layoutFile && layout != layoutDirectory {
+		return fmt.Errorf("layout must be either %v or %v, got '%v'", layoutFile, layoutDirectory, layout)
+	}
+	return nil
+}
+
+// layout : Returns the layout of the statefile, based on the kind of its path
+func (s *State) layout() string {
+	if info, err := os.Stat(s.Config.Path); err == nil && info.IsDir() {
+		return layoutDirectory
+	}
+	return layoutFile
+}
+
+// secretsDir : Returns the directory holding the secret files of a target, relative to
+// the state directory
+func secretsDir(target string) string {
+	if target == defaultTarget {
+		return "secrets"
+	}
+	return filepath.Join("targets", target, "secrets")
+}
+
+// secretPath : Returns the path of the file of a secret, relative to the state directory.
+// The names which would escape the directory of the secrets of the target are refused
+func secretPath(target, secret string) (string, error) {
+	if target != defaultTarget && (target == "." || target == ".." || strings.ContainsAny(target, `/\`)) {
+		return "", fmt.Errorf("invalid target name '%v', it cannot be used as a directory name", target)
+	}
+
+	if err := validateSecretName(secret); err != nil {
+		return "", err
+	}
+
+	if strings.Contains(secret, `\`) {
+		return "", fmt.Errorf("invalid secret name '%v', it cannot contain '\\' when using the directory layout", secret)
+	}
+	return filepath.Join(secretsDir(target), filepath.FromSlash(secret)+secretFileExtension), nil
+}
+
+// encodeYAML : Returns the YAML representation of a value, yaml.v3 sorts the keys of the
+// maps which makes it deterministic
+func encodeYAML(value interface{}) ([]byte, error) {
+	var output bytes.Buffer
+	y := yaml.NewEncoder(&output)
+	y.SetIndent(2)
+	if err := y.Encode(value); err != nil {
+		return nil, err
+	}
+
+	if err := y.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// directoryFiles : Returns the content of every file of the statefile when using the
+// directory layout, indexed by their paths relative to the state directory
+func (s *State) directoryFiles() (map[string][]byte, error) {
+	// The index holds everything but the secrets and their labels
+	index := *s
+	index.Default.Secrets, index.Default.Labels = nil, nil
+	index.Targets = make(map[string]*Target)
+	for name, t := range s.Targets {
+		target := *t
+		target.Secrets, target.Labels = nil, nil
+		index.Targets[name] = &target
+	}
+
+	data, err := encodeYAML(&index)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{stateIndexFile: data}
+
+	for _, name := range s.TargetNames() {
+		t := &s.Default
+		if name != defaultTarget {
+			t = s.Targets[name]
+		}
+
+		for secret, keys := range t.Secrets {
+			path, err := secretPath(name, secret)
+			if err != nil {
+				return nil, err
+			}
+
+			if data, err = encodeYAML(secretFile{Labels: t.Labels[secret], Keys: keys}); err != nil {
+				return nil, err
+			}
+			files[path] = data
+		}
+	}
+	return files, nil
+}
+
+// readDirectory : Returns the content of every file of a state directory, indexed by
+// their relative paths
+func readDirectory(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, secretFileExtension) {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files[rel], err = ioutil.ReadFile(filepath.Clean(path))
+		return err
+	})
+	return files, err
+}
+
+// loadDirectory : Loads the secrets of a state directory, the index must have been
+// loaded beforehand. Every file has to belong to one of the targets of the index
+func (s *State) loadDirectory(files map[string][]byte) error {
+	loaded := map[string]bool{stateIndexFile: true}
+	for _, name := range s.TargetNames() {
+		t := &s.Default
+		if name != defaultTarget {
+			t = s.Targets[name]
+		}
+
+		dir := secretsDir(name) + string(filepath.Separator)
+		for _, path := range sortedKeys(files) {
+			if !strings.HasPrefix(path, dir) {
+				continue
+			}
+
+			var sf secretFile
+			if err := yaml.Unmarshal(files[path], &sf); err != nil {
+				return fmt.Errorf("%v: %v", path, err)
+			}
+
+			secret := filepath.ToSlash(strings.TrimSuffix(strings.TrimPrefix(path, dir), secretFileExtension))
+			if expected, err := secretPath(name, secret); err != nil || expected != path {
+				return fmt.Errorf("%v: invalid secret file name", path)
+			}
+			loaded[path] = true
+
+			if t.Secrets == nil {
+				t.Secrets = make(map[string]map[string]SecretKey)
+			}
+			t.Secrets[secret] = sf.Keys
+
+			if len(sf.Labels) > 0 {
+				if t.Labels == nil {
+					t.Labels = make(map[string]map[string]string)
+				}
+				t.Labels[secret] = sf.Labels
+			}
+		}
+	}
+
+	for _, path := range sortedKeys(files) {
+		if !loaded[path] {
+			return fmt.Errorf("%v does not belong to any of the targets defined in %v", path, stateIndexFile)
+		}
+	}
+	return nil
+}
+
+// saveDirectory : Writes the statefile using the directory layout, only the files which
+// have changed are written and the ones of the secrets which do not exist anymore are
+// removed
+func (s *State) saveDirectory(dir string) error {
+	files, err := s.directoryFiles()
+	if err != nil {
+		return err
+	}
+
+	current, err := readDirectory(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range sortedKeys(files) {
+		if existing, found := current[path]; found && bytes.Equal(existing, files[path]) {
+			continue
+		}
+
+		if err = os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o750); err != nil {
+			return err
+		}
+
+		if err = writeFileAtomic(filepath.Join(dir, path), files[path], 0o600); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range sortedKeys(current) {
+		if _, found := files[path]; found || path == stateIndexFile {
+			continue
+		}
+
+		if err = os.Remove(filepath.Join(dir, path)); err != nil {
+			return err
+		}
+		removeEmptyDirs(dir, filepath.Dir(filepath.Join(dir, path)))
+	}
+	return nil
+}
+
+// removeEmptyDirs : Removes a directory and its parents as long as they are empty,
+// without going above root
+func removeEmptyDirs(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// fingerprintFiles : Returns a checksum of a set of files indexed by their paths
+func fingerprintFiles(files map[string][]byte) string {
+	h := sha256.New()
+	for _, path := range sortedKeys(files) {
+		fmt.Fprintf(h, "%v\x00%d\x00", filepath.ToSlash(path), len(files[path]))
+		h.Write(files[path])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// StateConvertResult : Location and layout of a converted statefile
+type StateConvertResult struct {
+	Path   string `json:"path" yaml:"path"`
+	Layout string `json:"layout" yaml:"layout"`
+}
+
+func (r StateConvertResult) renderTable(w io.Writer) {
+	fmt.Fprintf(w, "State written at %v using the %v layout\n", r.Path, r.Layout)
+}
+
+// StateConvert ..
+func StateConvert(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 2 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+
+	layout, destination := ctx.Args().Get(0), ctx.Args().Get(1)
+	if err := validateLayout(layout); err != nil {
+		return 1, err
+	}
+
+	if _, err := os.Stat(destination); !os.IsNotExist(err) {
+		return 1, fmt.Errorf("%v already exists", destination)
+	}
+
+	s.Load()
+	if layout == layoutDirectory {
+		if err := os.MkdirAll(destination, 0o750); err != nil {
+			return 1, err
+		}
+	}
+
+	s.Config.Path = destination
+	s.save()
+	log.Infof("Use '--state %v' (or STRONGBOX_STATE) to use it", destination)
+
+	if err := render(StateConvertResult{Path: destination, Layout: layout}); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
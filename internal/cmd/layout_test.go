@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateSaveIsSorted(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	for _, secret := range []string{"zeta", "alpha", "mid"} {
+		s.setSecretKey(secret, "b", SecretKey{Value: "2"})
+		s.setSecretKey(secret, "a", SecretKey{Value: "1"})
+	}
+
+	data, err := ioutil.ReadFile(s.Config.Path)
+	require.NoError(t, err)
+	assert.True(t, strings.Index(string(data), "alpha:") < strings.Index(string(data), "mid:"))
+	assert.True(t, strings.Index(string(data), "mid:") < strings.Index(string(data), "zeta:"))
+	assert.Contains(t, string(data), "  alpha:\n    a: \"1\"\n    b: \"2\"\n")
+
+	s.Load()
+	s.save()
+	saved, err := ioutil.ReadFile(s.Config.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, saved)
+}
+
+func TestStateDirectoryLayout(t *testing.T) {
+	dir, err := ioutil.TempDir(tmpDir, "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := getStateClient(&StateConfig{Path: dir})
+	s.Init()
+	assert.Equal(t, layoutDirectory, s.layout())
+	s.setSecretKey("foo", "a", SecretKey{Value: "1"})
+	s.setSecretKey("app/prod/db", "password", SecretKey{Value: "2"})
+	require.NoError(t, s.LabelSecret("foo", []string{"team=payments"}))
+	require.NoError(t, s.AddTarget("team"))
+	s.SelectTarget("team")
+	s.setSecretKey("bar", "b", SecretKey{Value: "3"})
+
+	for _, path := range []string{
+		stateIndexFile,
+		filepath.Join("secrets", "foo.yml"),
+		filepath.Join("secrets", "app", "prod", "db.yml"),
+		filepath.Join("targets", "team", "secrets", "bar.yml"),
+	} {
+		assert.FileExists(t, filepath.Join(dir, path))
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, stateIndexFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(index), "secrets")
+
+	fingerprint, err := s.Fingerprint()
+	require.NoError(t, err)
+
+	s = getStateClient(&StateConfig{Path: dir})
+	s.Load()
+	assert.Equal(t, "1", s.Default.Secrets["foo"]["a"].Value)
+	assert.Equal(t, "2", s.Default.Secrets["app/prod/db"]["password"].Value)
+	assert.Equal(t, map[string]string{"team": "payments"}, s.SecretLabels("foo"))
+	assert.Equal(t, "3", s.Targets["team"].Secrets["bar"]["b"].Value)
+
+	s.DeleteSecret("app/prod/db")
+	assert.NoDirExists(t, filepath.Join(dir, "secrets", "app"))
+	assert.FileExists(t, filepath.Join(dir, "secrets", "foo.yml"))
+
+	updated, err := s.Fingerprint()
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, updated)
+}
+
+func TestSecretPath(t *testing.T) {
+	path, err := secretPath(defaultTarget, "app/prod/db")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("secrets", "app", "prod", "db.yml"), path)
+
+	path, err = secretPath("team", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("targets", "team", "secrets", "foo.yml"), path)
+
+	for _, secret := range []string{"../foo", "app/../../foo", "/etc/passwd", `..\foo`, ""} {
+		_, err = secretPath(defaultTarget, secret)
+		assert.Error(t, err, secret)
+	}
+
+	_, err = secretPath("..", "foo")
+	assert.Error(t, err)
+}
+
+func TestStateDirectoryLayoutInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(tmpDir, "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := getStateClient(&StateConfig{Path: dir})
+	s.Init()
+	s.setSecretKey("foo", "a", SecretKey{Value: "1"})
+
+	files, err := readDirectory(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.loadDirectory(files))
+
+	// The secrets of a target which is not defined in the index are not silently dropped
+	files[filepath.Join("targets", "ghost", "secrets", "bar.yml")] = []byte("keys: {}\n")
+	assert.EqualError(t, s.loadDirectory(files), filepath.Join("targets", "ghost", "secrets", "bar.yml")+" does not belong to any of the targets defined in state.yml")
+
+	s.Default.Secrets["../escape"] = map[string]SecretKey{"a": {Value: "1"}}
+	_, err = s.directoryFiles()
+	assert.Error(t, err)
+}
@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Outcomes of the three-way merge of a value
+const (
+	mergeOurs = iota
+	mergeTheirs
+	mergeConflicting
+)
+
+// Kinds of values which may conflict
+const (
+	conflictKey   = "key"
+	conflictLabel = "label"
+	conflictVault = "vault"
+)
+
+// mergeConflictPlaceholder : Prefix of the values standing for the conflicts while
+// encoding the merged statefile, they are then replaced by the conflict markers
+const mergeConflictPlaceholder = "strongbox-merge-conflict-"
+
+// merge3 : Returns which side of a three-way merge has to be kept, a missing value is
+// represented by nil
+func merge3(base, ours, theirs interface{}) int {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return mergeOurs
+	case reflect.DeepEqual(base, ours):
+		return mergeTheirs
+	}
+	return mergeConflicting
+}
+
+// pick : Returns the value of the side kept by a three-way merge
+func pick(side int, ours, theirs interface{}) interface{} {
+	if side == mergeTheirs {
+		return theirs
+	}
+	return ours
+}
+
+// mergeConflict : Value changed differently on both sides of a merge
+type mergeConflict struct {
+	Target string
+	Secret string
+	Key    string
+	Kind   string
+	Ours   interface{}
+	Theirs interface{}
+}
+
+// String : Names the conflicting value
+func (c mergeConflict) String() string {
+	var parts []string
+	if c.Target != defaultTarget {
+		parts = append(parts, fmt.Sprintf("target '%v'", c.Target))
+	}
+
+	// Secret files of the directory layout are merged as a secret without a name
+	if c.Kind != conflictVault && c.Secret != "" {
+		parts = append(parts, fmt.Sprintf("secret '%v'", c.Secret))
+	}
+
+	switch c.Kind {
+	case conflictKey:
+		parts = append(parts, fmt.Sprintf("key '%v'", c.Key))
+	case conflictLabel:
+		parts = append(parts, fmt.Sprintf("label '%v'", c.Key))
+	case conflictVault:
+		parts = append(parts, fmt.Sprintf("vault configuration '%v'", c.Key))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// path : Returns the path of the conflicting value within the YAML document, secret files
+// of the directory layout only hold the keys and labels of a single secret
+func (c mergeConflict) path(isSecretFile bool) []string {
+	if isSecretFile {
+		if c.Kind == conflictLabel {
+			return []string{"labels", c.Key}
+		}
+		return []string{"keys", c.Key}
+	}
+
+	var path []string
+	if c.Target != defaultTarget {
+		path = []string{"targets", c.Target}
+	}
+
+	switch c.Kind {
+	case conflictKey:
+		return append(path, "secrets", c.Secret, c.Key)
+	case conflictLabel:
+		return append(path, "labels", c.Secret, c.Key)
+	}
+	return append(append(path, "vault"), strings.Split(c.Key, ".")...)
+}
+
+// lookup : Returns the value of a map for a key, nil if the map does not hold it
+func lookup(m interface{}, key string) interface{} {
+	v := reflect.ValueOf(m)
+	if !v.IsValid() || v.IsNil() {
+		return nil
+	}
+
+	if value := v.MapIndex(reflect.ValueOf(key)); value.IsValid() {
+		return value.Interface()
+	}
+	return nil
+}
+
+// unionKeys : Returns the sorted keys found in any of the maps
+func unionKeys(maps ...interface{}) (keys []string) {
+	found := make(map[string]bool)
+	for _, m := range maps {
+		for _, key := range sortedKeys(m) {
+			found[key] = true
+		}
+	}
+	return sortedKeys(found)
+}
+
+// targetsOf : Returns every target of a statefile, including the default one
+func targetsOf(s *State) map[string]*Target {
+	targets := map[string]*Target{defaultTarget: &s.Default}
+	for name, t := range s.Targets {
+		targets[name] = t
+	}
+	return targets
+}
+
+// mergeStates : Three-way merges statefiles, the conflicting values are set to ours, or
+// theirs when we removed them, and returned alongside the merged statefile
+func mergeStates(base, ours, theirs *State) (*State, []mergeConflict) {
+	merged := &State{Version: stateVersion}
+	var conflicts []mergeConflict
+
+	b, o, t := targetsOf(base), targetsOf(ours), targetsOf(theirs)
+	for _, name := range unionKeys(b, o, t) {
+		target, c := mergeTargets(name, b[name], o[name], t[name])
+		conflicts = append(conflicts, c...)
+		switch {
+		case target == nil:
+		case name == defaultTarget:
+			merged.Default = *target
+		default:
+			if merged.Targets == nil {
+				merged.Targets = make(map[string]*Target)
+			}
+			merged.Targets[name] = target
+		}
+	}
+	return merged, conflicts
+}
+
+// mergeTargets : Three-way merges a target, which may be missing on any side, nil is
+// returned when it has been removed
+func mergeTargets(name string, base, ours, theirs *Target) (*Target, []mergeConflict) {
+	var conflicts []mergeConflict
+	exists := pick(merge3(base != nil, ours != nil, theirs != nil), ours != nil, theirs != nil).(bool)
+
+	// Missing targets have no secrets nor configuration to compare against
+	vaultOf := func(t *Target) reflect.Value {
+		if t == nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(t.Vault)
+	}
+
+	merged := &Target{}
+	conflicts = append(conflicts, mergeVault(name, reflect.ValueOf(&merged.Vault).Elem(), vaultOf(base), vaultOf(ours), vaultOf(theirs), nil)...)
+
+	secretsOf := func(t *Target) map[string]map[string]SecretKey {
+		if t == nil {
+			return nil
+		}
+		return t.Secrets
+	}
+	labelsOf := func(t *Target) map[string]map[string]string {
+		if t == nil {
+			return nil
+		}
+		return t.Labels
+	}
+
+	bs, us, ts := secretsOf(base), secretsOf(ours), secretsOf(theirs)
+	bl, ul, tl := labelsOf(base), labelsOf(ours), labelsOf(theirs)
+	for _, secret := range unionKeys(bs, us, ts) {
+		conflicting := len(conflicts)
+		keys := make(map[string]SecretKey)
+		for _, key := range unionKeys(bs[secret], us[secret], ts[secret]) {
+			b, o, t := lookup(bs[secret], key), lookup(us[secret], key), lookup(ts[secret], key)
+			side := merge3(b, o, t)
+			if side == mergeConflicting {
+				conflicts = append(conflicts, mergeConflict{Target: name, Secret: secret, Key: key, Kind: conflictKey, Ours: o, Theirs: t})
+				if side = mergeOurs; o == nil {
+					side = mergeTheirs
+				}
+			}
+
+			if value := pick(side, o, t); value != nil {
+				keys[key] = value.(SecretKey)
+			}
+		}
+
+		labels := make(map[string]string)
+		for _, label := range unionKeys(bl[secret], ul[secret], tl[secret]) {
+			b, o, t := lookup(bl[secret], label), lookup(ul[secret], label), lookup(tl[secret], label)
+			side := merge3(b, o, t)
+			if side == mergeConflicting {
+				conflicts = append(conflicts, mergeConflict{Target: name, Secret: secret, Key: label, Kind: conflictLabel, Ours: o, Theirs: t})
+				if side = mergeOurs; o == nil {
+					side = mergeTheirs
+				}
+			}
+
+			if value := pick(side, o, t); value != nil {
+				labels[label] = value.(string)
+			}
+		}
+
+		// A secret removed on one side is kept when the other one changed it
+		_, inBase := bs[secret]
+		_, inOurs := us[secret]
+		_, inTheirs := ts[secret]
+		if len(keys) == 0 && len(conflicts) == conflicting && !pick(merge3(inBase, inOurs, inTheirs), inOurs, inTheirs).(bool) {
+			continue
+		}
+
+		if merged.Secrets == nil {
+			merged.Secrets = make(map[string]map[string]SecretKey)
+		}
+		merged.Secrets[secret] = keys
+
+		if len(labels) > 0 {
+			if merged.Labels == nil {
+				merged.Labels = make(map[string]map[string]string)
+			}
+			merged.Labels[secret] = labels
+		}
+	}
+
+	if !exists && len(merged.Secrets) == 0 && len(conflicts) == 0 {
+		return nil, nil
+	}
+	return merged, conflicts
+}
+
+// mergeVault : Three-way merges the Vault configuration of a target field by field, into
+// merged, so that only the fields changed differently on both sides conflict. The fields
+// are named after their YAML keys, the sides of a missing target are invalid values
+func mergeVault(name string, merged, base, ours, theirs reflect.Value, path []string) (conflicts []mergeConflict) {
+	// Unset fields are omitted from the statefile, they are handled as missing values
+	field := func(v reflect.Value, i int) interface{} {
+		if !v.IsValid() || v.Field(i).IsZero() {
+			return nil
+		}
+		return v.Field(i).Interface()
+	}
+
+	sub := func(v reflect.Value, i int) reflect.Value {
+		if !v.IsValid() {
+			return v
+		}
+		return v.Field(i)
+	}
+
+	for i := 0; i < merged.NumField(); i++ {
+		f := merged.Type().Field(i)
+		key := strings.ToLower(f.Name)
+		if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" {
+			key = tag
+		}
+
+		if f.Type.Kind() == reflect.Struct {
+			conflicts = append(conflicts, mergeVault(name, merged.Field(i), sub(base, i), sub(ours, i), sub(theirs, i), append(path, key))...)
+			continue
+		}
+
+		b, o, t := field(base, i), field(ours, i), field(theirs, i)
+		side := merge3(b, o, t)
+		if side == mergeConflicting {
+			conflicts = append(conflicts, mergeConflict{Target: name, Key: strings.Join(append(path, key), "."), Kind: conflictVault, Ours: o, Theirs: t})
+			if side = mergeOurs; o == nil {
+				side = mergeTheirs
+			}
+		}
+
+		if value := pick(side, o, t); value != nil {
+			merged.Field(i).Set(reflect.ValueOf(value))
+		}
+	}
+	return
+}
+
+// isSecretFile : Returns true if the document is the file of a secret from the
+// directory layout, rather than a statefile or the index of a state directory
+func isSecretFile(data []byte) (bool, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false, err
+	}
+
+	_, keys := doc["keys"]
+	_, version := doc["version"]
+	return keys && !version, nil
+}
+
+// parseMergeSide : Parses one of the sides of a merge into a statefile, secret files are
+// loaded as the only secret of the default target
+func parseMergeSide(data []byte, asSecretFile bool) (*State, error) {
+	st := &State{}
+	if asSecretFile {
+		var sf secretFile
+		if err := yaml.Unmarshal(data, &sf); err != nil {
+			return nil, err
+		}
+
+		st.Default.Secrets = map[string]map[string]SecretKey{"": sf.Keys}
+		if sf.Labels != nil {
+			st.Default.Labels = map[string]map[string]string{"": sf.Labels}
+		}
+		return st, nil
+	}
+
+	migrated, _, err := migrateState(data)
+	if err != nil {
+		return nil, err
+	}
+	return st, yaml.Unmarshal(migrated, st)
+}
+
+// findMappingValue : Returns the node holding the value found at a path of mapping keys
+func findMappingValue(node *yaml.Node, path []string) *yaml.Node {
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// renderMerge : Encodes the merged document, replacing each conflicting value with git
+// styled conflict markers naming it and surrounding both versions of it
+func renderMerge(merged interface{}, conflicts []mergeConflict, isSecretFile bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(merged); err != nil {
+		return nil, err
+	}
+
+	for i, c := range conflicts {
+		node := findMappingValue(&doc, c.path(isSecretFile))
+		if node == nil {
+			return nil, fmt.Errorf("unable to locate the conflict on %v", c)
+		}
+		*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprintf("%v%d", mergeConflictPlaceholder, i)}
+	}
+
+	data, err := encodeYAML(&doc)
+	if err != nil || len(conflicts) == 0 {
+		return data, err
+	}
+
+	var output bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		trimmed := strings.TrimRight(line, "\n")
+		idx := strings.LastIndex(trimmed, ": "+mergeConflictPlaceholder)
+		if idx == -1 {
+			output.WriteString(line)
+			continue
+		}
+
+		var i int
+		if _, err = fmt.Sscanf(trimmed[idx+2:], mergeConflictPlaceholder+"%d", &i); err != nil || i >= len(conflicts) {
+			output.WriteString(line)
+			continue
+		}
+
+		c := conflicts[i]
+		indent := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, " "))]
+		path := c.path(isSecretFile)
+		name := path[len(path)-1]
+
+		fmt.Fprintf(&output, "<<<<<<< ours (%v)\n", c)
+		if err = writeIndented(&output, indent, name, c.Ours); err != nil {
+			return nil, err
+		}
+		output.WriteString("=======\n")
+		if err = writeIndented(&output, indent, name, c.Theirs); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&output, ">>>>>>> theirs (%v)\n", c)
+	}
+	return output.Bytes(), nil
+}
+
+// writeIndented : Writes a single entry mapping at the given indentation, nothing is
+// written for a missing value
+func writeIndented(w *bytes.Buffer, indent, name string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	data, err := encodeYAML(map[string]interface{}{name: value})
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" {
+			w.WriteString(indent + line)
+		}
+	}
+	return nil
+}
+
+// mergeFiles : Three-way merges the content of statefiles, or of secret files from the
+// directory layout, returns the merged content and the conflicts
+func mergeFiles(base, ours, theirs []byte) ([]byte, []mergeConflict, error) {
+	asSecretFile, err := isSecretFile(ours)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ours: %v", err)
+	}
+
+	var sides [3]*State
+	for i, side := range []struct {
+		name string
+		data []byte
+	}{{"ancestor", base}, {"ours", ours}, {"theirs", theirs}} {
+		if sides[i], err = parseMergeSide(side.data, asSecretFile); err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", side.name, err)
+		}
+	}
+
+	merged, conflicts := mergeStates(sides[0], sides[1], sides[2])
+	if !asSecretFile {
+		data, err := renderMerge(merged, conflicts, false)
+		return data, conflicts, err
+	}
+
+	sf := secretFile{Keys: merged.Default.Secrets[""], Labels: merged.Default.Labels[""]}
+	if sf.Keys == nil {
+		sf.Keys = make(map[string]SecretKey)
+	}
+	data, err := renderMerge(sf, conflicts, true)
+	return data, conflicts, err
+}
+
+// MergeDriver ..
+func MergeDriver(ctx *cli.Context) (int, error) {
+	if ctx.NArg() < 3 || ctx.NArg() > 4 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+
+	var files [3][]byte
+	for i := range files {
+		data, err := ioutil.ReadFile(filepath.Clean(ctx.Args().Get(i)))
+		if err != nil {
+			return 1, err
+		}
+		files[i] = data
+	}
+
+	merged, conflicts, err := mergeFiles(files[0], files[1], files[2])
+	if err != nil {
+		return 1, err
+	}
+
+	// The result of the merge is expected to be written into the file holding ours
+	if err = writeFileAtomic(ctx.Args().Get(1), merged, 0o600); err != nil {
+		return 1, err
+	}
+
+	if len(conflicts) > 0 {
+		pathname := ctx.Args().Get(3)
+		if pathname == "" {
+			pathname = ctx.Args().Get(1)
+		}
+
+		for _, c := range conflicts {
+			log.Errorf("%v: conflict on %v", pathname, c)
+		}
+		return 1, nil
+	}
+	return 0, nil
+}
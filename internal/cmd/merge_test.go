@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const mergeTestBase = `version: 4
+vault:
+  transitkey: foo
+  kv:
+    path: secret/
+    version: 1
+secrets:
+  foo:
+    a: "1"
+    b: "2"
+  bar:
+    c: "3"
+labels:
+  foo:
+    team: payments
+`
+
+func TestMergeFiles(t *testing.T) {
+	ours := `version: 4
+vault:
+  transitkey: foo
+  kv:
+    path: secret/
+    version: 1
+secrets:
+  foo:
+    a: "10"
+    b: "2"
+  bar:
+    c: "3"
+labels:
+  foo:
+    team: payments
+    env: prod
+`
+	theirs := `version: 4
+vault:
+  transitkey: foo
+  kv:
+    path: secret/
+    version: 2
+secrets:
+  foo:
+    a: "1"
+    b: "20"
+  baz:
+    d: "4"
+labels:
+  foo:
+    team: payments
+`
+
+	merged, conflicts, err := mergeFiles([]byte(mergeTestBase), []byte(ours), []byte(theirs))
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	var st State
+	require.NoError(t, yaml.Unmarshal(merged, &st))
+	assert.Equal(t, 2, st.Default.Vault.KV.Version)
+	assert.Equal(t, []string{"baz", "foo"}, sortedKeys(st.Default.Secrets))
+	assert.Equal(t, "10", st.Default.Secrets["foo"]["a"].Value)
+	assert.Equal(t, "20", st.Default.Secrets["foo"]["b"].Value)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, st.Default.Labels["foo"])
+}
+
+func TestMergeFilesConflict(t *testing.T) {
+	ours := strings.Replace(mergeTestBase, `b: "2"`, `b: "11"`, 1) + "targets:\n  team:\n    vault:\n      transitkey: team\n"
+	theirs := `version: 4
+vault:
+  transitkey: foo
+  kv:
+    path: secret/
+    version: 1
+secrets:
+  foo:
+    a: "1"
+    b: "22"
+labels:
+  foo:
+    team: search
+`
+
+	merged, conflicts, err := mergeFiles([]byte(mergeTestBase), []byte(ours), []byte(theirs))
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "secret 'foo', key 'b'", conflicts[0].String())
+	assert.Contains(t, string(merged), `    a: "1"
+<<<<<<< ours (secret 'foo', key 'b')
+    b: "11"
+=======
+    b: "22"
+>>>>>>> theirs (secret 'foo', key 'b')
+`)
+	assert.NotContains(t, string(merged), "bar:")
+	assert.Contains(t, string(merged), "team: search")
+	assert.Contains(t, string(merged), "transitkey: team")
+}
+
+func TestMergeFilesVault(t *testing.T) {
+	base := mergeTestBase + "targets:\n  team:\n    vault:\n      transitkey: team\n      kv:\n        path: team/\n        version: 2\n"
+	ours := strings.Replace(base, "        version: 2\n", "        version: 2\n        deletemode: destroy\n", 1)
+	ours = strings.Replace(ours, "    version: 1\n", "    version: 2\n", 1)
+	theirs := strings.Replace(base, "      transitkey: team\n", "      transitkey: team\n      transitkeys:\n        - pattern: db\n          key: db\n", 1)
+	theirs = strings.Replace(theirs, "    version: 1\n", "    version: 1\n    ownership: marked\n", 1)
+
+	// Fields changed on a single side are merged
+	merged, conflicts, err := mergeFiles([]byte(base), []byte(ours), []byte(theirs))
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	var st State
+	require.NoError(t, yaml.Unmarshal(merged, &st))
+	assert.Equal(t, 2, st.Default.Vault.KV.Version)
+	assert.Equal(t, ownershipMarked, st.Default.Vault.KV.Ownership)
+	assert.Equal(t, deleteModeDestroy, st.Targets["team"].Vault.KV.DeleteMode)
+	assert.Equal(t, []TransitKeyRule{{Pattern: "db", Key: "db"}}, st.Targets["team"].Vault.TransitKeys)
+	assert.Equal(t, "team/", st.Targets["team"].Vault.KV.Path)
+
+	// Only the fields changed differently on both sides conflict
+	theirs = strings.Replace(theirs, "        version: 2\n", "        version: 2\n        deletemode: metadata\n", 1)
+	merged, conflicts, err = mergeFiles([]byte(base), []byte(ours), []byte(theirs))
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "target 'team', vault configuration 'kv.deletemode'", conflicts[0].String())
+	assert.Contains(t, string(merged), `<<<<<<< ours (target 'team', vault configuration 'kv.deletemode')
+        deletemode: destroy
+=======
+        deletemode: metadata
+>>>>>>> theirs (target 'team', vault configuration 'kv.deletemode')
+`)
+	assert.Contains(t, string(merged), "pattern: db")
+}
+
+func TestMergeSecretFiles(t *testing.T) {
+	base := "keys:\n  a: \"1\"\n  b: \"2\"\n"
+	ours := "keys:\n  a: \"10\"\n  b: \"2\"\n"
+	theirs := "labels:\n  team: payments\nkeys:\n  a: \"20\"\n  b: \"2\"\n  c: \"3\"\n"
+
+	merged, conflicts, err := mergeFiles([]byte(base), []byte(ours), []byte(theirs))
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, `labels:
+  team: payments
+keys:
+<<<<<<< ours (key 'a')
+  a: "10"
+=======
+  a: "20"
+>>>>>>> theirs (key 'a')
+  b: "2"
+  c: "3"
+`, string(merged))
+}
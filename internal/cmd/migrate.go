@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// stateMigration : Upgrades the content of a statefile from a schema version to the next one
+type stateMigration struct {
+	Description string
+	Migrate     func(doc map[string]interface{}) error
+}
+
+// stateMigrations : Migrations of the statefile schema, the one at index N upgrades
+// a statefile from version N to version N+1
+var stateMigrations = []stateMigration{
+	{
+		Description: "set the default KV path and version of the targets explicitly",
+		Migrate:     migrateExplicitKVDefaults,
+	},
+	{
+		// The layout remains compatible but older versions of strongbox would silently
+		// drop the metadata of the keys when saving the statefile
+		Description: "keys can hold metadata alongside their values",
+		Migrate:     func(map[string]interface{}) error { return nil },
+	},
+	{
+		// Same goes for the labels of the secrets
+		Description: "secrets can hold labels",
+		Migrate:     func(map[string]interface{}) error { return nil },
+	},
+	{
+		// The index of a state directory does not contain any secret, older versions of
+		// strongbox must not be able to load it as a regular statefile
+		Description: "secrets can be stored into their own files within a state directory",
+		Migrate:     func(map[string]interface{}) error { return nil },
+	},
+}
+
+// stateVersion : Current version of the statefile schema
+var stateVersion = len(stateMigrations)
+
+// migrateState : Upgrades the content of a statefile to the current schema version,
+// alongside the content it also returns the version the statefile has been written with
+func migrateState(data []byte) ([]byte, int, error) {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	version := 0
+	if value, found := doc["version"]; found {
+		var ok bool
+		if version, ok = value.(int); !ok || version < 0 {
+			return nil, 0, fmt.Errorf("invalid statefile schema version '%v'", value)
+		}
+	}
+
+	if version > stateVersion {
+		return nil, version, fmt.Errorf("the statefile has been written using schema version %d but this version of strongbox only supports up to version %d, please upgrade strongbox", version, stateVersion)
+	}
+
+	if version == stateVersion {
+		return data, version, nil
+	}
+
+	for i, m := range stateMigrations[version:] {
+		log.Debugf("Migrating statefile schema from version %d to %d: %v", version+i, version+i+1, m.Description)
+		if err := m.Migrate(doc); err != nil {
+			return nil, version, fmt.Errorf("migrating statefile schema to version %d: %v", version+i+1, err)
+		}
+	}
+	doc["version"] = stateVersion
+
+	migrated, err := yaml.Marshal(doc)
+	return migrated, version, err
+}
+
+// migrateExplicitKVDefaults : Versions 0 of the statefiles were relying on the KV path
+// to default to 'secret/' and the KV version to 1 when they were not defined
+func migrateExplicitKVDefaults(doc map[string]interface{}) error {
+	targets := []map[string]interface{}{doc}
+	if t, found := doc["targets"]; found {
+		named, ok := t.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("targets must be a mapping")
+		}
+
+		for _, name := range sortedKeys(named) {
+			target, ok := named[name].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("target '%v' must be a mapping", name)
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	for _, target := range targets {
+		vault, err := mappingField(target, "vault")
+		if err != nil {
+			return err
+		}
+
+		kv, err := mappingField(vault, "kv")
+		if err != nil {
+			return err
+		}
+
+		if path, _ := kv["path"].(string); path == "" {
+			kv["path"] = "secret/"
+		}
+
+		if version, _ := kv["version"].(int); version == 0 {
+			kv["version"] = 1
+		}
+	}
+	return nil
+}
+
+// mappingField : Returns the mapping stored under a key of a parent one, creating it
+// when it does not exist
+func mappingField(parent map[string]interface{}, key string) (map[string]interface{}, error) {
+	value, found := parent[key]
+	if !found || value == nil {
+		m := map[string]interface{}{}
+		parent[key] = m
+		return m, nil
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v must be a mapping", key)
+	}
+	return m, nil
+}
+
+// StateMigrateResult : Schema versions of the statefile before and after its migration
+type StateMigrateResult struct {
+	From int `json:"from" yaml:"from"`
+	To   int `json:"to" yaml:"to"`
+}
+
+func (r StateMigrateResult) renderTable(w io.Writer) {
+	if r.From == r.To {
+		fmt.Fprintf(w, "State file already uses the latest schema version (%d)\n", r.To)
+		return
+	}
+	fmt.Fprintf(w, "State file migrated from schema version %d to %d\n", r.From, r.To)
+}
+
+// StateMigrate ..
+func StateMigrate(_ *cli.Context) (int, error) {
+	s.LoadForUpdate()
+
+	r := StateMigrateResult{From: s.loadedVersion, To: stateVersion}
+	if r.From != r.To {
+		s.save()
+	}
+
+	if err := render(r); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateState(t *testing.T) {
+	data := []byte(`vault:
+  transitkey: foo
+targets:
+  team:
+    vault:
+      kv:
+        path: kv-team/
+secrets:
+  foo:
+    bar: "{{s5:Zm9v}}"
+`)
+
+	s := getTestStateClient()
+	require.NoError(t, ioutil.WriteFile(s.Config.Path, data, 0o600))
+	s.Load()
+	assert.Equal(t, stateVersion, s.Version)
+	assert.Equal(t, 0, s.loadedVersion)
+	assert.Equal(t, "foo", s.VaultTransitKey())
+	assert.Equal(t, "secret/", s.VaultKVPath())
+	assert.Equal(t, 1, s.VaultKVVersion())
+	assert.Equal(t, "{{s5:Zm9v}}", s.ReadSecretKey("foo", "bar").Value)
+
+	s.SelectTarget("team")
+	assert.Equal(t, "kv-team/", s.VaultKVPath())
+	assert.Equal(t, 1, s.VaultKVVersion())
+}
+
+func TestMigrateStateCurrentVersion(t *testing.T) {
+	data := []byte(fmt.Sprintf("version: %d\nvault:\n  kv:\n    path: foo/\n", stateVersion))
+	migrated, version, err := migrateState(data)
+	require.NoError(t, err)
+	assert.Equal(t, stateVersion, version)
+	assert.Equal(t, data, migrated)
+}
+
+func TestMigrateStateNewerVersion(t *testing.T) {
+	_, _, err := migrateState([]byte("version: 99\n"))
+	assert.Error(t, err)
+
+	_, _, err = migrateState([]byte("version: foo\n"))
+	assert.Error(t, err)
+}
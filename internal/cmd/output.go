@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported output formats
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+	outputFormatYAML  = "yaml"
+)
+
+// outputFormat : Format used to render the results of the commands
+var outputFormat = outputFormatTable
+
+// result is implemented by every typed command result, it can be serialized
+// as JSON or YAML as-is and knows how to render itself in a human readable way
+type result interface {
+	renderTable(w io.Writer)
+}
+
+// setOutputFormat : Validates and configures the output format
+func setOutputFormat(format string) error {
+	switch format {
+	case outputFormatTable, outputFormatJSON, outputFormatYAML:
+		outputFormat = format
+		return nil
+	default:
+		return fmt.Errorf("invalid output format '%v', must be one of: table, json, yaml", format)
+	}
+}
+
+// render : Prints a command result on stdout using the configured output format
+func render(r result) error {
+	return renderTo(os.Stdout, r)
+}
+
+func renderTo(w io.Writer, r result) error {
+	switch outputFormat {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		r.renderTable(w)
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOutputFormat(t *testing.T) {
+	defer func() { outputFormat = outputFormatTable }()
+
+	assert.NoError(t, setOutputFormat("json"))
+	assert.Equal(t, outputFormatJSON, outputFormat)
+	assert.Error(t, setOutputFormat("xml"))
+}
+
+func TestRenderPlanResult(t *testing.T) {
+	defer func() { outputFormat = outputFormatTable }()
+
+	r := newPlanResult(defaultTarget, computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": "1", "b": "2"}},
+		map[string]map[string]interface{}{"foo": {"a": "0"}, "bar": {"c": "3"}},
+	), deleteModeSoft)
+
+	var buf bytes.Buffer
+	require.NoError(t, setOutputFormat("json"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.JSONEq(t, `{
+		"target": "default",
+		"add": {"secrets": 0, "keys": 1},
+		"update": {"secrets": 1, "keys": 1},
+		"remove": {"secrets": 1, "keys": 1},
+		"delete_mode": "soft",
+		"operations": [
+			{"action": "add", "secret": "foo", "key": "b"},
+			{"action": "update", "secret": "foo", "key": "a"},
+			{"action": "delete", "secret": "bar", "keys": ["c"]}
+		]
+	}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, setOutputFormat("yaml"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.Contains(t, buf.String(), "- action: add\n    secret: foo\n    key: b\n")
+
+	buf.Reset()
+	require.NoError(t, setOutputFormat("table"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.Contains(t, buf.String(), "~> foo:a (value changed)")
+	assert.Contains(t, buf.String(), "=> bar:c")
+	assert.Contains(t, buf.String(), "Secrets will be deleted using the 'soft' mode")
+}
+
+func TestRenderApplyResults(t *testing.T) {
+	defer func() { outputFormat = outputFormatTable }()
+
+	r := &ApplyResults{}
+	r.add("dev", deleteModeSoft, nil, nil)
+	r.add("prod", deleteModeDestroy, []AppliedChange{
+		{Action: appliedWrite, Secret: "foo"},
+		{Action: appliedDelete, Secret: "bar", Error: "permission denied"},
+	}, errors.New("unable to update 1 path(s) of the Vault KV"))
+
+	var buf bytes.Buffer
+	require.NoError(t, setOutputFormat("json"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.JSONEq(t, `{
+		"targets": [
+			{"target": "dev", "delete_mode": "soft", "changes": []},
+			{
+				"target": "prod",
+				"delete_mode": "destroy",
+				"changes": [
+					{"action": "write", "secret": "foo"},
+					{"action": "delete", "secret": "bar", "error": "permission denied"}
+				],
+				"error": "unable to update 1 path(s) of the Vault KV"
+			}
+		]
+	}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, setOutputFormat("table"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.Contains(t, buf.String(), "[dev]\nNothing to do!")
+	assert.Contains(t, buf.String(), "=> Added/Updated secret 'foo' and managed keys")
+	assert.Contains(t, buf.String(), "!> delete bar: permission denied")
+}
+
+func TestRenderSecretReadResult(t *testing.T) {
+	defer func() { outputFormat = outputFormatTable }()
+
+	r := SecretReadResult{Secret: "foo", Key: "bar", Value: "baz"}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderTo(&buf, r))
+	assert.Equal(t, "baz\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, setOutputFormat("json"))
+	require.NoError(t, renderTo(&buf, r))
+	assert.JSONEq(t, `{"secret": "foo", "key": "bar", "value": "baz"}`, buf.String())
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultParallelism : Amount of requests made concurrently onto the Vault KV
+const defaultParallelism = 10
+
+var parallelism = defaultParallelism
+
+// setParallelism : Configures the amount of requests made concurrently onto the Vault KV
+func setParallelism(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("parallelism must be greater than 0, got %d", workers)
+	}
+	parallelism = workers
+	return nil
+}
+
+// forEachParallel : Runs fn onto each of the names using a bounded pool of workers, the
+// errors are collected rather than interrupting the others and returned indexed by name
+func forEachParallel(names []string, fn func(name string) error) map[string]error {
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := parallelism
+	if len(names) < workers {
+		workers = len(names)
+	}
+
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				if err := fn(name); err != nil {
+					mu.Lock()
+					errs[name] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+
+	return errs
+}
+
+// kvError : Reports the errors which occurred on some paths of the Vault KV, one per
+// path, and returns an error summarizing them
+func kvError(action string, errs map[string]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	for _, name := range sortedKeys(errs) {
+		log.Errorf("Unable to %v '%v': %v", action, name, errs[name])
+	}
+	return fmt.Errorf("unable to %v %v path(s) of the Vault KV", action, len(errs))
+}
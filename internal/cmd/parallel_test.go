@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetParallelism(t *testing.T) {
+	defer func() { parallelism = defaultParallelism }()
+
+	assert.NoError(t, setParallelism(4))
+	assert.Equal(t, 4, parallelism)
+	assert.Error(t, setParallelism(0))
+	assert.Equal(t, 4, parallelism)
+}
+
+func TestForEachParallel(t *testing.T) {
+	defer func() { parallelism = defaultParallelism }()
+	require.NoError(t, setParallelism(2))
+
+	var running, maxRunning, calls int32
+	errs := forEachParallel([]string{"a", "b", "c", "d", "e"}, func(name string) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for max := atomic.LoadInt32(&maxRunning); current > max; max = atomic.LoadInt32(&maxRunning) {
+			if atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&calls, 1)
+		if name == "b" || name == "d" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+
+	assert.Equal(t, int32(5), calls)
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Equal(t, []string{"b", "d"}, sortedKeys(errs))
+	assert.Empty(t, forEachParallel(nil, func(string) error { return fmt.Errorf("failed") }))
+}
+
+func TestKVError(t *testing.T) {
+	assert.NoError(t, kvError("read", map[string]error{}))
+	assert.EqualError(t, kvError("read", map[string]error{"a": fmt.Errorf("failed"), "b": fmt.Errorf("failed")}), "unable to read 2 path(s) of the Vault KV")
+}
@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// planFileFormatVersion : Version of the plan file format, bumped on breaking changes
+const planFileFormatVersion = 2
+
+// PlanFile : Machine-readable representation of a plan, which can be applied later on.
+// It never contains plaintext values, only ciphertexts and keyed checksums.
+type PlanFile struct {
+	FormatVersion int `json:"format_version"`
+	Vault         struct {
+		Address string `json:"address"`
+	} `json:"vault"`
+
+	// StateFingerprint is the checksum of the state file at the time of the plan
+	StateFingerprint string `json:"state_fingerprint"`
+
+	// Targets holds the plan of every target of the state file, indexed by their names
+	Targets map[string]*PlanTarget `json:"targets"`
+}
+
+// PlanTarget : Plan of the operations to perform onto the Vault KV of a target
+type PlanTarget struct {
+	KV struct {
+		Path       string `json:"path"`
+		Version    int    `json:"version"`
+		DeleteMode string `json:"delete_mode,omitempty"`
+	} `json:"kv"`
+
+	// Remote holds the checksum of the remote content of every secret affected by
+	// the plan on KV v1, an empty value means that the secret did not exist
+	Remote map[string]string `json:"remote,omitempty"`
+
+	// ChecksumKey is the random key of the checksums of the plan, ciphered using the
+	// transit key of the target so that they cannot be brute-forced from the plan file
+	ChecksumKey string `json:"checksum_key,omitempty"`
+
+	// Versions holds the KV v2 version of every secret affected by the plan, it is used
+	// to detect the secrets updated since the plan and to perform check-and-set writes
+	Versions map[string]int `json:"versions,omitempty"`
+
+	Operations []PlanOperation `json:"operations"`
+}
+
+// PlanOperation : An operation to perform onto a secret or one of its keys.
+// When Key is empty, the operation applies to the whole secret and Keys lists
+// the keys it contains.
+type PlanOperation struct {
+	Action string   `json:"action" yaml:"action"`
+	Secret string   `json:"secret" yaml:"secret"`
+	Key    string   `json:"key,omitempty" yaml:"key,omitempty"`
+	Keys   []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Value  string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Type   string   `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// Labels to write into the custom metadata of the secret
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// newPlanFile : Generates an empty plan file
+func newPlanFile() *PlanFile {
+	p := &PlanFile{
+		FormatVersion: planFileFormatVersion,
+		Targets:       make(map[string]*PlanTarget),
+	}
+	p.Vault.Address = v.Client.Address()
+	return p
+}
+
+// addTarget : Adds the plan of the currently selected target, out of a diff and the
+// remote values it was computed from
+func (p *PlanFile) addTarget(changes *diff, remote *remoteSecrets) {
+	t := &PlanTarget{
+		Versions:   make(map[string]int),
+		Operations: []PlanOperation{},
+	}
+
+	t.KV.Path = s.VaultKVPath()
+	t.KV.Version = s.VaultKVVersion()
+	t.KV.DeleteMode = kvDeleteMode()
+
+	for _, o := range changes.operations() {
+		if o.Action == "add" || o.Action == "update" {
+			o.Value = s.target().Secrets[o.Secret][o.Key].Value
+			o.Type = s.target().Secrets[o.Secret][o.Key].Type
+		}
+		t.Operations = append(t.Operations, o)
+	}
+
+	for _, secret := range t.secrets() {
+		if version, found := remote.Versions[secret]; found {
+			t.Versions[secret] = version
+		}
+	}
+
+	// KV v2 secrets are compared using their versions, their values are not needed
+	if t.KV.Version != 2 && len(t.Operations) > 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Error: unable to generate the checksum key of the plan: %v", err)
+		}
+		t.ChecksumKey = v.cipher(s.VaultTransitKey(), hex.EncodeToString(key))
+
+		t.Remote = make(map[string]string)
+		for _, secret := range t.secrets() {
+			t.Remote[secret] = ""
+			if values, found := remote.Values[secret]; found {
+				t.Remote[secret] = checksum(key, values)
+			}
+		}
+	}
+
+	p.Targets[s.TargetName()] = t
+}
+
+// secrets : Returns the secrets affected by the operations of the plan
+func (t *PlanTarget) secrets() (secrets []string) {
+	seen := make(map[string]bool)
+	for _, o := range t.Operations {
+		if !seen[o.Secret] {
+			seen[o.Secret] = true
+			secrets = append(secrets, o.Secret)
+		}
+	}
+	return
+}
+
+// checksumKey : Returns the deciphered key of the checksums of the plan
+func (t *PlanTarget) checksumKey() ([]byte, error) {
+	key, err := v.decipher(s.VaultTransitKey(), t.ChecksumKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decipher the checksum key of the plan: %v", err)
+	}
+	return hex.DecodeString(key)
+}
+
+// loadPlanFile : Reads a plan file from the disk
+func loadPlanFile(path string) (*PlanFile, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plan file: %v", err)
+	}
+
+	p := &PlanFile{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("unable to parse plan file: %v", err)
+	}
+
+	if p.FormatVersion != planFileFormatVersion {
+		return nil, fmt.Errorf("unsupported plan file format version %d, expected %d", p.FormatVersion, planFileFormatVersion)
+	}
+
+	return p, nil
+}
+
+// save : Writes the plan file onto the disk, an interrupted write never leaves a
+// truncated plan file behind
+func (p *PlanFile) save(path string) (err error) {
+	if p.StateFingerprint, err = s.Fingerprint(); err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return
+	}
+
+	log.Debugf("Saving plan file at %v", path)
+	return writeFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// verify : Ensures that the state file has not changed since the plan was generated
+// and that it is applied against the same Vault cluster
+func (p *PlanFile) verify() error {
+	fingerprint, err := s.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	if fingerprint != p.StateFingerprint {
+		return fmt.Errorf("state file has changed since the plan was generated, please run 'strongbox plan' again")
+	}
+
+	if p.Vault.Address != v.Client.Address() {
+		return fmt.Errorf("plan was generated against '%v', currently configured Vault address is '%v'", p.Vault.Address, v.Client.Address())
+	}
+
+	return nil
+}
+
+// verify : Ensures that the remote secrets of the currently selected target have not
+// drifted since the plan was generated
+func (t *PlanTarget) verify(remote *remoteSecrets) error {
+	if t.KV.Path != s.VaultKVPath() || t.KV.Version != s.VaultKVVersion() {
+		return fmt.Errorf("plan was generated against KV path '%v' (v%d), currently configured one is '%v' (v%d)", t.KV.Path, t.KV.Version, s.VaultKVPath(), s.VaultKVVersion())
+	}
+
+	if t.KV.Version == 2 {
+		for _, secret := range t.secrets() {
+			if remote.Versions[secret] != t.Versions[secret] {
+				return driftError(secret)
+			}
+		}
+		return nil
+	}
+
+	if len(t.Remote) == 0 {
+		return nil
+	}
+
+	key, err := t.checksumKey()
+	if err != nil {
+		return err
+	}
+
+	for secret, sum := range t.Remote {
+		current := ""
+		if values, found := remote.Values[secret]; found {
+			current = checksum(key, values)
+		}
+
+		if !hmac.Equal([]byte(current), []byte(sum)) {
+			return driftError(secret)
+		}
+	}
+
+	return nil
+}
+
+func driftError(secret string) error {
+	return fmt.Errorf("secret '%v' has changed in Vault since the plan was generated, please run 'strongbox plan' again", secret)
+}
+
+// applyPlanFile : Performs exactly the operations recorded in a plan file
+func applyPlanFile(path string) (int, error) {
+	p, err := loadPlanFile(path)
+	if err != nil {
+		return 1, err
+	}
+
+	if err = p.verify(); err != nil {
+		return 1, err
+	}
+
+	defer s.SelectTarget(s.selected)
+
+	// Every target is verified before applying any change
+	remotes := make(map[string]*remoteSecrets)
+	for _, target := range sortedKeys(p.Targets) {
+		if target != defaultTarget && s.Targets[target] == nil {
+			return 1, fmt.Errorf("target '%v' of the plan does not exist in the state file", target)
+		}
+
+		s.SelectTarget(target)
+		if remotes[target], err = fetchRemoteSecrets(); err != nil {
+			return 1, err
+		}
+
+		if err = p.Targets[target].verify(remotes[target]); err != nil {
+			return 1, fmt.Errorf("target '%v': %v", target, err)
+		}
+	}
+
+	// A target which cannot be fully applied does not prevent the others from being applied
+	results := &ApplyResults{}
+	for _, target := range sortedKeys(p.Targets) {
+		s.SelectTarget(target)
+		changes, err := p.Targets[target].apply(remotes[target])
+		results.add(target, p.Targets[target].KV.DeleteMode, changes, err)
+	}
+
+	return results.exit()
+}
+
+// apply : Performs the operations of the plan onto the currently selected target
+func (t *PlanTarget) apply(remote *remoteSecrets) ([]AppliedChange, error) {
+	// Secrets get deleted the way it was displayed when the plan was generated
+	s.target().Vault.KV.DeleteMode = t.KV.DeleteMode
+
+	payloads := make(map[string]map[string]interface{})
+	deleteKeys := make(map[string][]string)
+	writeSecrets := make(map[string]bool)
+	labels := make(map[string]map[string]string)
+	ciphered := make(map[string]map[string]SecretKey)
+	var deleteSecrets []string
+	for _, o := range t.Operations {
+		if o.Action == "label" {
+			labels[o.Secret] = o.Labels
+			continue
+		}
+
+		if o.Action == "delete" && o.Key == "" {
+			deleteSecrets = append(deleteSecrets, o.Secret)
+			continue
+		}
+
+		if o.Action == "delete" {
+			deleteKeys[o.Secret] = append(deleteKeys[o.Secret], o.Key)
+		} else {
+			writeSecrets[o.Secret] = true
+		}
+
+		if payloads[o.Secret] == nil {
+			payloads[o.Secret] = make(map[string]interface{})
+			for key, value := range remote.Values[o.Secret] {
+				payloads[o.Secret][key] = value
+			}
+		}
+
+		switch o.Action {
+		case "add", "update":
+			if ciphered[o.Secret] == nil {
+				ciphered[o.Secret] = make(map[string]SecretKey)
+			}
+			ciphered[o.Secret][o.Key] = SecretKey{Value: o.Value, Type: o.Type}
+		case "delete":
+			delete(payloads[o.Secret], o.Key)
+		default:
+			return nil, fmt.Errorf("unknown plan operation '%v' on %v:%v", o.Action, o.Secret, o.Key)
+		}
+	}
+
+	// The values of the plan are deciphered all at once, in batches
+	values, errs := decipherValues(ciphered)
+	for _, secret := range sortedKeys(errs) {
+		for _, key := range sortedKeys(errs[secret]) {
+			return nil, fmt.Errorf("unable to decode %v:%v from the plan file: %v", secret, key, errs[secret][key])
+		}
+	}
+
+	for secret, keys := range values {
+		for key, value := range keys {
+			payloads[secret][key] = value
+		}
+	}
+
+	// Writes are made against the versions recorded in the plan, they get refused by
+	// Vault if the secrets have been updated in the meantime
+	c := &kvChanges{
+		Writes:        make(map[string]map[string]interface{}),
+		DeleteKeys:    make(map[string][]string),
+		Versions:      t.Versions,
+		Labels:        labels,
+		DeleteSecrets: deleteSecrets,
+	}
+
+	for secret, payload := range payloads {
+		if _, exists := remote.Values[secret]; exists && !writeSecrets[secret] {
+			c.DeleteKeys[secret] = deleteKeys[secret]
+		} else {
+			c.Writes[secret] = payload
+		}
+	}
+
+	return c.apply()
+}
+
+// checksum : Returns a HMAC-SHA256 checksum of a set of key/values
+func checksum(key []byte, values map[string]interface{}) string {
+	// json.Marshal sorts map keys, which makes the output deterministic
+	data, _ := json.Marshal(values)
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(data)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestPlanFile(t *testing.T) (*PlanFile, *remoteSecrets) {
+	c, err := api.NewClient(nil)
+	require.NoError(t, err)
+	v = &Vault{c}
+
+	s = getTestStateClient()
+	s.Init()
+	return newTestPlanFile(t)
+}
+
+func newTestPlanFile(t *testing.T) (*PlanFile, *remoteSecrets) {
+	s.WriteSecretKey("foo", "a", SecretKey{Value: "{{s5:Zm9v}}"})
+	s.WriteSecretKey("foo", "b", SecretKey{Value: "{{s5:YmFy}}"})
+
+	local := map[string]map[string]interface{}{
+		"foo": {"a": "1", "b": "2"},
+	}
+
+	remote := map[string]map[string]interface{}{
+		"foo": {"a": "1", "b": "old", "c": "3"},
+		"bar": {"a": "1"},
+	}
+
+	r := &remoteSecrets{
+		Values:   remote,
+		Versions: map[string]int{"foo": 3, "bar": 1, "baz": 2},
+	}
+
+	p := newPlanFile()
+	p.addTarget(computeDiff(local, remote), r)
+	return p, r
+}
+
+func TestNewPlanFile(t *testing.T) {
+	p, _ := getTestPlanFile(t)
+	require.Contains(t, p.Targets, defaultTarget)
+
+	pt := p.Targets[defaultTarget]
+	assert.Equal(t, "secret/", pt.KV.Path)
+	assert.Equal(t, 2, pt.KV.Version)
+	assert.Equal(t, []PlanOperation{
+		{Action: "update", Secret: "foo", Key: "b", Value: "{{s5:YmFy}}"},
+		{Action: "delete", Secret: "foo", Key: "c"},
+		{Action: "delete", Secret: "bar", Keys: []string{"a"}},
+	}, pt.Operations)
+	assert.Empty(t, pt.Remote)
+	assert.Empty(t, pt.ChecksumKey)
+	assert.Equal(t, map[string]int{"foo": 3, "bar": 1}, pt.Versions)
+}
+
+func TestPlanFileSaveAndLoad(t *testing.T) {
+	p, remote := getTestPlanFile(t)
+	path := s.Config.Path + ".plan"
+	require.NoError(t, p.save(path))
+
+	loaded, err := loadPlanFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, p, loaded)
+	assert.NoError(t, loaded.verify())
+	assert.NoError(t, loaded.Targets[defaultTarget].verify(remote))
+}
+
+func TestPlanFileVerifyDrift(t *testing.T) {
+	p, remote := getTestPlanFile(t)
+	require.NoError(t, p.save(s.Config.Path+".plan"))
+
+	// KV v2 secrets are compared using their versions
+	remote.Versions["bar"] = 2
+	assert.NoError(t, p.verify())
+	assert.Error(t, p.Targets[defaultTarget].verify(remote))
+
+	remote.Versions["bar"] = 1
+	remote.Versions["created"] = 1
+	assert.NoError(t, p.Targets[defaultTarget].verify(remote))
+
+	s.WriteSecretKey("foo", "d", SecretKey{Value: "{{s5:YmF6}}"})
+	assert.Error(t, p.verify())
+}
+
+func TestPlanFileKV1Checksums(t *testing.T) {
+	newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+	s.SetVaultKVVersion(1)
+
+	p, remote := newTestPlanFile(t)
+	pt := p.Targets[defaultTarget]
+	require.NotEmpty(t, pt.ChecksumKey)
+	assert.Len(t, pt.Remote, 2)
+
+	// The checksums are keyed, the ones of the same values differ from a plan to another
+	other, _ := newTestPlanFile(t)
+	assert.NotEqual(t, pt.Remote["foo"], other.Targets[defaultTarget].Remote["foo"])
+	data, _ := json.Marshal(remote.Values["foo"])
+	sum := sha256.Sum256(data)
+	assert.NotContains(t, pt.Remote["foo"], hex.EncodeToString(sum[:]))
+
+	assert.NoError(t, pt.verify(remote))
+	remote.Values["bar"]["a"] = "changed"
+	assert.Error(t, pt.verify(remote))
+	remote.Values["bar"]["a"] = "1"
+	delete(remote.Values, "foo")
+	assert.Error(t, pt.verify(remote))
+}
+
+func TestChecksum(t *testing.T) {
+	key := []byte("key")
+	assert.Equal(t, checksum(key, map[string]interface{}{"a": "1", "b": "2"}), checksum(key, map[string]interface{}{"b": "2", "a": "1"}))
+	assert.NotEqual(t, checksum(key, map[string]interface{}{"a": "1"}), checksum(key, map[string]interface{}{"a": "2"}))
+	assert.NotEqual(t, checksum(key, map[string]interface{}{"a": "1"}), checksum([]byte("other"), map[string]interface{}{"a": "1"}))
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Pull ..
+func Pull(ctx *cli.Context) (int, error) {
+	sel, err := selectorFromContext(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	if ctx.Bool("dry-run") {
+		s.Load()
+	} else {
+		s.LoadForUpdate()
+	}
+
+	local, remote, err := fetchSelectedValues(sel)
+	if err != nil {
+		return 1, err
+	}
+
+	// The comparison is made the other way round, Vault being the source of truth
+	changes := computeDiff(remote.Values, local.Values)
+	changes.ignoreInaccessibleKeys(local.Inaccessible)
+	changes.ignoreAddedSecrets(remote.unmanaged)
+	changes.ignoreAddedKeys(s.isMerged)
+
+	if !ctx.Bool("delete") {
+		if count := len(changes.DeleteSecrets) + changes.count(changes.DeleteKeys); count > 0 {
+			log.Infof("%v secret(s)/key(s) only exist in the statefile and will be kept, use --delete to remove them", count)
+		}
+		changes.DeleteSecrets = nil
+		changes.DeleteKeys = make(map[string][]string)
+	}
+
+	if err = render(newPlanResult(s.TargetName(), changes, "")); err != nil {
+		return 1, err
+	}
+
+	if ctx.Bool("dry-run") || changes.empty() {
+		return 0, nil
+	}
+
+	if err = pull(changes, remote.Values); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// pull : Updates the statefile with the remote values referenced in the diff
+func pull(changes *diff, remote map[string]map[string]interface{}) error {
+	if s.target().Secrets == nil {
+		s.target().Secrets = map[string]map[string]SecretKey{}
+	}
+
+	// The values of all the changed keys are ciphered at once, in batches
+	values := make(map[string]map[string]interface{})
+	for _, secret := range changes.changedSecrets() {
+		for _, keys := range [][]string{changes.AddKeys[secret], changes.UpdateKeys[secret]} {
+			for _, key := range keys {
+				if values[secret] == nil {
+					values[secret] = make(map[string]interface{})
+				}
+				values[secret][key] = remote[secret][key]
+			}
+		}
+	}
+
+	ciphered, err := cipherValues(values)
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range changes.changedSecrets() {
+		if s.target().Secrets[secret] == nil {
+			s.target().Secrets[secret] = map[string]SecretKey{}
+		}
+
+		for key, value := range ciphered[secret] {
+			s.target().Secrets[secret][key] = s.target().updatedSecretKey(secret, key, value)
+		}
+
+		for _, key := range changes.DeleteKeys[secret] {
+			delete(s.target().Secrets[secret], key)
+		}
+	}
+
+	for _, secret := range changes.DeleteSecrets {
+		delete(s.target().Secrets, secret)
+		delete(s.target().Labels, secret)
+	}
+
+	s.save()
+	log.Infof("Pulled %v secret(s) from Vault into the statefile", len(changes.changedSecrets())+len(changes.DeleteSecrets))
+	return nil
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullDeletions(t *testing.T) {
+	s = getTestStateClient()
+	s.Init()
+	s.setSecretKey("foo", "a", SecretKey{Value: "{{s5:Zm9v}}"})
+	s.setSecretKey("foo", "b", SecretKey{Value: "{{s5:YmFy}}"})
+	s.setSecretKey("bar", "a", SecretKey{Value: "{{s5:YmF6}}"})
+
+	remote := map[string]map[string]interface{}{
+		"foo": {"a": "foo"},
+	}
+	local := map[string]map[string]interface{}{
+		"foo": {"a": "foo", "b": "bar"},
+		"bar": {"a": "baz"},
+	}
+
+	pull(computeDiff(remote, local), remote)
+	s.Load()
+	assert.Equal(t, map[string]map[string]SecretKey{"foo": {"a": {Value: "{{s5:Zm9v}}"}}}, s.Default.Secrets)
+}
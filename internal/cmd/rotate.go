@@ -0,0 +1,445 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rotationJournalExtension : Extension of the journal of a rotation, it lives next to the
+// statefile until the rotation has completed
+const rotationJournalExtension = ".rotation"
+
+// rewrapOperation : Operation of the journal of a rewrap, the ones of the rotations
+// between transit keys do not define any
+const rewrapOperation = "rewrap"
+
+// rotationJournalHeader : First line of a rotation journal, its entries can only be
+// reused by a rotation of the same target between the same transit keys, or by a rewrap
+type rotationJournalHeader struct {
+	Operation string `json:"operation,omitempty"`
+	Target    string `json:"target"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// rotationJournalEntry : Value which has been re-ciphered using the new transit key, it
+// only applies as long as the statefile still holds the previous one. The target is only
+// defined by the rewraps, which apply to several targets at once
+type rotationJournalEntry struct {
+	Target   string `json:"target,omitempty"`
+	Secret   string `json:"secret"`
+	Key      string `json:"key"`
+	Previous string `json:"previous"`
+	Value    string `json:"value"`
+}
+
+// rotationJournal : Records the values re-ciphered by a rotation as they get processed, so
+// that an interrupted rotation can be resumed without ciphering them again
+type rotationJournal struct {
+	path    string
+	header  rotationJournalHeader
+	entries map[string]map[string]rotationJournalEntry
+	file    *os.File
+}
+
+// rotationJournalPath : Returns the path of the rotation journal of a statefile
+func rotationJournalPath(statePath string) (string, error) {
+	path, err := filepath.Abs(statePath)
+	if err != nil {
+		return "", err
+	}
+	return path + rotationJournalExtension, nil
+}
+
+// readRotationJournal : Reads the entries recorded by an interrupted rotation. The journal
+// of another operation is refused rather than overwritten, it holds the only copy of the
+// values it re-ciphered
+func readRotationJournal(path string, header rotationJournalHeader) (*rotationJournal, error) {
+	j := &rotationJournal{
+		path:    path,
+		header:  header,
+		entries: make(map[string]map[string]rotationJournalEntry),
+	}
+
+	f, err := os.Open(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return j, scanner.Err()
+	}
+
+	var h rotationJournalHeader
+	if err = json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return nil, fmt.Errorf("unable to parse the header of %v: %v, delete it if it is not needed anymore", path, err)
+	}
+
+	if h != header {
+		return nil, fmt.Errorf("%v holds the values of an interrupted %v, run it again in order to resume it or delete the journal to discard them", path, h.operation())
+	}
+
+	for scanner.Scan() {
+		// The last entry may have been partially written when the rotation got interrupted
+		var e rotationJournalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			break
+		}
+		j.add(e)
+	}
+	return j, scanner.Err()
+}
+
+// operation : Describes the command which has written the journal
+func (h rotationJournalHeader) operation() string {
+	if h.Operation == rewrapOperation {
+		return "'secret rewrap'"
+	}
+	return fmt.Sprintf("'secret rotate-from %v' of target '%v' to transit key '%v'", h.From, h.Target, h.To)
+}
+
+// journalSecret : Returns the index of a secret within the entries of a journal
+func journalSecret(target, secret string) string {
+	if target == "" {
+		return secret
+	}
+	return target + "\x00" + secret
+}
+
+// add : Indexes an entry of the journal
+func (j *rotationJournal) add(e rotationJournalEntry) {
+	secret := journalSecret(e.Target, e.Secret)
+	if j.entries[secret] == nil {
+		j.entries[secret] = make(map[string]rotationJournalEntry)
+	}
+	j.entries[secret][e.Key] = e
+}
+
+// lookup : Returns the re-ciphered value of a key, if it has been recorded while the key
+// was holding its current value
+func (j *rotationJournal) lookup(target, secret, key, current string) (string, bool) {
+	e, found := j.entries[journalSecret(target, secret)][key]
+	if !found || e.Previous != current {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// open : Rewrites the journal with the entries it holds, new entries are then appended
+// to it as they get recorded
+func (j *rotationJournal) open() (err error) {
+	if j.file, err = os.OpenFile(filepath.Clean(j.path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+		return
+	}
+
+	if err = j.write(j.header); err != nil {
+		return
+	}
+
+	for _, secret := range sortedKeys(j.entries) {
+		for _, key := range sortedKeys(j.entries[secret]) {
+			if err = j.write(j.entries[secret][key]); err != nil {
+				return
+			}
+		}
+	}
+	return j.file.Sync()
+}
+
+// record : Appends an entry to the journal, it is flushed to the disk before returning
+func (j *rotationJournal) record(e rotationJournalEntry) error {
+	if err := j.write(e); err != nil {
+		return err
+	}
+	j.add(e)
+	return j.file.Sync()
+}
+
+func (j *rotationJournal) write(line interface{}) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+// remove : Deletes the journal once the rotation has completed
+func (j *rotationJournal) remove() error {
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+	}
+	return os.Remove(j.path)
+}
+
+// SecretRotateFromResult : Outcome of the rotation of the values from an old transit key
+type SecretRotateFromResult struct {
+	From         string `json:"from" yaml:"from"`
+	To           string `json:"to" yaml:"to"`
+	DryRun       bool   `json:"dry_run" yaml:"dry_run"`
+	Rotated      int    `json:"rotated" yaml:"rotated"`
+	Resumed      int    `json:"resumed" yaml:"resumed"`
+	Inaccessible int    `json:"inaccessible" yaml:"inaccessible"`
+
+	// Failed counts the values which cannot be deciphered, they abort an actual rotation
+	Failed int `json:"failed" yaml:"failed"`
+}
+
+func (r SecretRotateFromResult) renderTable(w io.Writer) {
+	if r.DryRun {
+		fmt.Fprintf(w, "%v value(s) would be rotated from '%v' to '%v'\n", r.Rotated, r.From, r.To)
+	} else {
+		fmt.Fprintf(w, "Rotated %v value(s) from '%v' to '%v'\n", r.Rotated, r.From, r.To)
+	}
+
+	if r.Resumed > 0 {
+		fmt.Fprintf(w, "%v of them had already been re-ciphered by an interrupted rotation\n", r.Resumed)
+	}
+
+	if r.Inaccessible > 0 {
+		fmt.Fprintf(w, "%v inaccessible value(s) have not been rotated\n", r.Inaccessible)
+	}
+
+	if r.Failed > 0 {
+		fmt.Fprintf(w, "%v value(s) cannot be deciphered, the rotation would fail\n", r.Failed)
+	}
+}
+
+// SecretRewrapResult : Outcome of the rewrap of the values using the latest versions of
+// their transit keys
+type SecretRewrapResult struct {
+	Rewrapped             int            `json:"rewrapped" yaml:"rewrapped"`
+	Resumed               int            `json:"resumed" yaml:"resumed"`
+	UpToDate              int            `json:"up_to_date" yaml:"up_to_date"`
+	Inaccessible          int            `json:"inaccessible" yaml:"inaccessible"`
+	MinDecryptionVersions map[string]int `json:"min_decryption_versions,omitempty" yaml:"min_decryption_versions,omitempty"`
+}
+
+func (r SecretRewrapResult) renderTable(w io.Writer) {
+	fmt.Fprintf(w, "Rewrapped %v value(s), %v were already using the latest version of their transit key\n", r.Rewrapped, r.UpToDate)
+	if r.Resumed > 0 {
+		fmt.Fprintf(w, "%v of them had already been rewrapped by an interrupted rewrap\n", r.Resumed)
+	}
+	if r.Inaccessible > 0 {
+		fmt.Fprintf(w, "%v inaccessible value(s) have not been rewrapped\n", r.Inaccessible)
+	}
+
+	for _, key := range sortedKeys(r.MinDecryptionVersions) {
+		fmt.Fprintf(w, "Minimum decryption version of transit key '%v' raised to %v\n", key, r.MinDecryptionVersions[key])
+	}
+}
+
+// RewrapSecrets : Ciphers the values of the secrets using the latest versions of their
+// transit keys, without deciphering them locally. The values are journaled as they get
+// rewrapped and the statefile is saved once all of them have been. The minimum decryption
+// version of the transit keys can then be raised so that the previous versions cannot be
+// used anymore, in which case the values of every target get rewrapped
+func (s *State) RewrapSecrets(raiseMinDecryptionVersion bool) SecretRewrapResult {
+	path, err := rotationJournalPath(s.Config.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	journal, err := readRotationJournal(path, rotationJournalHeader{Operation: rewrapOperation})
+	if err != nil {
+		log.Fatalf("Error: unable to read the rotation journal: %v", err)
+	}
+
+	if err = journal.open(); err != nil {
+		log.Fatalf("Error: unable to write the rotation journal: %v", err)
+	}
+
+	targets := s.selectedTargetNames()
+	if raiseMinDecryptionVersion {
+		targets = s.TargetNames()
+	}
+
+	var r SecretRewrapResult
+	latest := make(map[string]int)
+	rewrapped := make(map[string]map[string]map[string]string)
+	setRewrapped := func(target, secret, key, value string) {
+		if rewrapped[target] == nil {
+			rewrapped[target] = make(map[string]map[string]string)
+		}
+
+		if rewrapped[target][secret] == nil {
+			rewrapped[target][secret] = make(map[string]string)
+		}
+		rewrapped[target][secret][key] = value
+		r.Rewrapped++
+	}
+
+	var pending []*transitItem
+	for _, name := range targets {
+		t := &s.Default
+		if name != defaultTarget {
+			t = s.Targets[name]
+		}
+
+		for _, k := range sortedKeys(t.Secrets) {
+			for _, m := range sortedKeys(t.Secrets[k]) {
+				transitKey := transitKeyFor(t.Vault.TransitKeys, t.Vault.TransitKey, k, m)
+				if _, found := latest[transitKey]; !found {
+					version, err := v.transitKeyLatestVersion(transitKey)
+					if err != nil && !isInaccessible(err) {
+						log.Fatal(err)
+					}
+
+					// Tokens may be allowed to rewrap values without being allowed to read the
+					// transit key, its values then all get sent to Vault
+					if err != nil {
+						log.Debugf("Unable to read the latest version of transit key '%v', rewrapping all of its values: %v", transitKey, err)
+					}
+					latest[transitKey] = version
+				}
+
+				current := t.Secrets[k][m].Value
+				if value, resumed := journal.lookup(name, k, m, current); resumed {
+					r.Resumed++
+					setRewrapped(name, k, m, value)
+					continue
+				}
+
+				version, err := ciphertextVersion(current)
+				if err != nil {
+					log.Fatalf("Error: %v:%v: %v", k, m, err)
+				}
+
+				// The latest version of the transit keys which cannot be read is unknown (0)
+				if latest[transitKey] > 0 && version >= latest[transitKey] {
+					r.UpToDate++
+					continue
+				}
+				pending = append(pending, &transitItem{TransitKey: transitKey, Target: name, Secret: k, Key: m, Input: current})
+			}
+		}
+	}
+
+	for _, batch := range transitBatches(pending) {
+		v.transitBatch(transitRewrap, batch)
+		for _, item := range batch {
+			if isInaccessible(item.Err) {
+				log.Warnf("Skipping %v:%v, %v", item.Secret, item.Key, item.Err)
+				r.Inaccessible++
+				continue
+			}
+
+			if item.Err != nil {
+				log.Fatal(item.Err)
+			}
+
+			// Values which were already using the latest version are left untouched
+			if rewrappedUpToDate(item) {
+				r.UpToDate++
+				continue
+			}
+
+			if err = journal.record(rotationJournalEntry{Target: item.Target, Secret: item.Secret, Key: item.Key, Previous: item.Input, Value: item.Output}); err != nil {
+				log.Fatalf("Error: unable to write the rotation journal: %v", err)
+			}
+			setRewrapped(item.Target, item.Secret, item.Key, item.Output)
+		}
+	}
+
+	// The values themselves remain the same, so does their metadata
+	for name, secrets := range rewrapped {
+		t := &s.Default
+		if name != defaultTarget {
+			t = s.Targets[name]
+		}
+
+		for k, l := range secrets {
+			for m, n := range l {
+				value := t.Secrets[k][m]
+				value.Value = n
+				t.Secrets[k][m] = value
+			}
+		}
+	}
+
+	if r.Rewrapped > 0 {
+		s.save()
+	}
+
+	if err = journal.remove(); err != nil {
+		log.Warnf("Unable to remove the rotation journal at %v: %v", path, err)
+	}
+
+	if !raiseMinDecryptionVersion {
+		return r
+	}
+
+	for _, transitKey := range sortedKeys(latest) {
+		if latest[transitKey] == 0 {
+			log.Warnf("Not raising the minimum decryption version of transit key '%v', its latest version cannot be read", transitKey)
+			continue
+		}
+
+		if outdated := s.outdatedValues(transitKey, latest[transitKey]); outdated > 0 {
+			log.Warnf("Not raising the minimum decryption version of transit key '%v', %v value(s) of the state file are still using a previous version", transitKey, outdated)
+			continue
+		}
+
+		if err := v.SetTransitKeyMinDecryptionVersion(transitKey, latest[transitKey]); err != nil {
+			log.Fatal(err)
+		}
+
+		if r.MinDecryptionVersions == nil {
+			r.MinDecryptionVersions = make(map[string]int)
+		}
+		r.MinDecryptionVersions[transitKey] = latest[transitKey]
+	}
+	return r
+}
+
+// rewrappedUpToDate : Returns true if the value rewrapped by Vault uses the same version
+// of its transit key as the one it replaces
+func rewrappedUpToDate(item *transitItem) bool {
+	previous, err := ciphertextVersion(item.Input)
+	if err != nil {
+		return false
+	}
+
+	version, err := ciphertextVersion(item.Output)
+	return err == nil && version <= previous
+}
+
+// outdatedValues : Returns the number of values of all the targets which have been
+// ciphered using a version of the transit key older than the given one
+func (s *State) outdatedValues(transitKey string, version int) (outdated int) {
+	for _, name := range s.TargetNames() {
+		t := &s.Default
+		if name != defaultTarget {
+			t = s.Targets[name]
+		}
+
+		for k, l := range t.Secrets {
+			for m, n := range l {
+				if transitKeyFor(t.Vault.TransitKeys, t.Vault.TransitKey, k, m) != transitKey {
+					continue
+				}
+
+				if current, err := ciphertextVersion(n.Value); err != nil || current < version {
+					outdated++
+				}
+			}
+		}
+	}
+	return
+}
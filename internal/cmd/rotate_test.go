@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotationJournal(t *testing.T) {
+	s := getTestStateClient()
+	path, err := rotationJournalPath(s.Config.Path)
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	header := rotationJournalHeader{Target: defaultTarget, From: "old", To: "new"}
+	j, err := readRotationJournal(path, header)
+	require.NoError(t, err)
+	require.NoError(t, j.open())
+	require.NoError(t, j.record(rotationJournalEntry{Secret: "foo", Key: "bar", Previous: "{{s5:b2xk}}", Value: "{{s5:bmV3}}"}))
+
+	// Simulates an interruption while writing an entry
+	_, err = j.file.WriteString(`{"secret":"foo","key":"ba`)
+	require.NoError(t, err)
+	require.NoError(t, j.file.Close())
+
+	j, err = readRotationJournal(path, header)
+	require.NoError(t, err)
+	value, found := j.lookup("", "foo", "bar", "{{s5:b2xk}}")
+	assert.True(t, found)
+	assert.Equal(t, "{{s5:bmV3}}", value)
+
+	_, found = j.lookup("", "foo", "bar", "{{s5:Y2hhbmdlZA==}}")
+	assert.False(t, found)
+
+	// The journal of another operation is never overwritten
+	_, err = readRotationJournal(path, rotationJournalHeader{Target: defaultTarget, From: "other", To: "new"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "holds the values of an interrupted 'secret rotate-from old' of target 'default' to transit key 'new'")
+
+	_, err = readRotationJournal(path, rotationJournalHeader{Operation: rewrapOperation})
+	assert.Error(t, err)
+
+	require.NoError(t, j.open())
+	require.NoError(t, j.remove())
+	assert.NoFileExists(t, path)
+}
+
+func TestStateRotateFromOldTransitKeyDryRun(t *testing.T) {
+	f := newFakeVault(t)
+	s := getTestStateClient()
+	s.Init()
+	s.setSecretKey("foo", "bar", SecretKey{Value: v.cipher("default", "old")})
+	s.setSecretKey("foo", "baz", SecretKey{Value: v.cipher("default", "old")})
+	s.setSecretKey("foo", "qux", SecretKey{Value: "{{s5:b2xk}}"})
+	s.SetVaultTransitKey("new")
+
+	data, err := ioutil.ReadFile(s.Config.Path)
+	require.NoError(t, err)
+
+	// Only the values which can actually be deciphered are reported as rotated
+	r := s.RotateFromOldTransitKey("default", nil, true)
+	assert.Equal(t, SecretRotateFromResult{From: "default", To: "new", DryRun: true, Rotated: 2, Failed: 1}, r)
+
+	f.Forbidden["default"] = true
+	r = s.RotateFromOldTransitKey("default", nil, true)
+	assert.Equal(t, SecretRotateFromResult{From: "default", To: "new", DryRun: true, Inaccessible: 3}, r)
+	assert.NotContains(t, f.Requests, "PUT transit/encrypt/new")
+
+	saved, err := ioutil.ReadFile(s.Config.Path)
+	require.NoError(t, err)
+	assert.Equal(t, data, saved)
+
+	path, err := rotationJournalPath(s.Config.Path)
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+}
+
+func TestStateRewrapSecretsTargets(t *testing.T) {
+	f := newFakeVault(t)
+	s := getTestStateClient()
+	s.Init()
+	s.setSecretKey("foo", "bar", SecretKey{Value: v.cipher("default", "1")})
+	require.NoError(t, s.AddTarget("team"))
+	s.SelectTarget("team")
+	s.setSecretKey("baz", "qux", SecretKey{Value: v.cipher("default", "2")})
+	s.setSecretKey("baz", "quux", SecretKey{Value: v.cipher("default", "3")})
+	f.TransitKeys["default"].LatestVersion = 2
+
+	// An interrupted rewrap is resumed from its journal
+	path, err := rotationJournalPath(s.Config.Path)
+	require.NoError(t, err)
+	j, err := readRotationJournal(path, rotationJournalHeader{Operation: rewrapOperation})
+	require.NoError(t, err)
+	require.NoError(t, j.open())
+	require.NoError(t, j.record(rotationJournalEntry{Target: "team", Secret: "baz", Key: "quux", Previous: s.target().Secrets["baz"]["quux"].Value, Value: "{{s5:v2=cmVzdW1lZA==}}"}))
+
+	// Without raising the minimum decryption version, only the selected target is rewrapped
+	r := s.RewrapSecrets(false)
+	assert.Equal(t, SecretRewrapResult{Rewrapped: 2, Resumed: 1}, r)
+	assert.Equal(t, "{{s5:v2=cmVzdW1lZA==}}", s.target().Secrets["baz"]["quux"].Value)
+	assert.True(t, strings.HasPrefix(s.target().Secrets["baz"]["qux"].Value, "{{s5:v2="))
+	assert.True(t, strings.HasPrefix(s.Default.Secrets["foo"]["bar"].Value, "{{s5:"))
+	assert.False(t, strings.HasPrefix(s.Default.Secrets["foo"]["bar"].Value, "{{s5:v2="))
+	assert.NoFileExists(t, path)
+
+	// Every target using the transit key gets rewrapped before the previous versions are
+	// prevented from deciphering values
+	r = s.RewrapSecrets(true)
+	assert.Equal(t, SecretRewrapResult{Rewrapped: 1, UpToDate: 2, MinDecryptionVersions: map[string]int{"default": 2}}, r)
+	assert.True(t, strings.HasPrefix(s.Default.Secrets["foo"]["bar"].Value, "{{s5:v2="))
+	assert.Equal(t, 2, f.TransitKeys["default"].MinDecryptionVersion)
+
+	value, err := v.decipher("default", s.Default.Secrets["foo"]["bar"].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "1", value)
+	assert.NoFileExists(t, path)
+}
+
+func TestStateRewrapSecretsUnreadableKey(t *testing.T) {
+	f := newFakeVault(t)
+	s := getTestStateClient()
+	s.Init()
+	s.setSecretKey("foo", "bar", SecretKey{Value: v.cipher("default", "1")})
+	f.TransitKeys["default"].LatestVersion = 2
+	s.setSecretKey("foo", "baz", SecretKey{Value: v.cipher("default", "2")})
+	upToDate := s.target().Secrets["foo"]["baz"].Value
+
+	// Tokens which cannot read the transit key are still allowed to rewrap its values
+	f.Unreadable["default"] = true
+	r := s.RewrapSecrets(false)
+	assert.Equal(t, SecretRewrapResult{Rewrapped: 1, UpToDate: 1}, r)
+	assert.True(t, strings.HasPrefix(s.target().Secrets["foo"]["bar"].Value, "{{s5:v2="))
+	assert.Equal(t, upToDate, s.target().Secrets["foo"]["baz"].Value)
+
+	// The minimum decryption version is left untouched, the latest version being unknown
+	r = s.RewrapSecrets(true)
+	assert.Equal(t, SecretRewrapResult{UpToDate: 2}, r)
+	assert.Equal(t, 1, f.TransitKeys["default"].MinDecryptionVersion)
+}
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/mvisonneau/strongbox/pkg/rand"
 	"github.com/tcnksm/go-input"
@@ -19,11 +21,29 @@ func SecretRead(ctx *cli.Context) (int, error) {
 		return 1, nil
 	}
 	s.Load()
-	fmt.Println(v.Decipher(s.ReadSecretKey(ctx.String("secret"), ctx.String("key"))))
+	value, err := v.Decipher(ctx.String("secret"), ctx.String("key"), s.ReadSecretKey(ctx.String("secret"), ctx.String("key")).Value)
+	if err != nil {
+		return 1, err
+	}
 
+	if err = render(SecretReadResult{Secret: ctx.String("secret"), Key: ctx.String("key"), Value: value}); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
+// SecretReadResult : Deciphered value of a secret key
+type SecretReadResult struct {
+	Secret string `json:"secret" yaml:"secret"`
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+}
+
+// renderTable only prints the value so that it can be piped into other commands
+func (r SecretReadResult) renderTable(w io.Writer) {
+	fmt.Fprintln(w, r.Value)
+}
+
 // SecretWrite ..
 func SecretWrite(ctx *cli.Context) (int, error) {
 	if ctx.String("secret") == "" ||
@@ -38,12 +58,23 @@ func SecretWrite(ctx *cli.Context) (int, error) {
 		return 1, fmt.Errorf("invalid arguments provided")
 	}
 
-	s.Load()
+	if err := validateSecretName(ctx.String("secret")); err != nil {
+		return 1, err
+	}
+
+	s.LoadForUpdate()
+
+	secret := SecretKey{
+		SecretKeyMetadata: SecretKeyMetadata{
+			Description: ctx.String("description"),
+			Owner:       ctx.String("owner"),
+		},
+	}
 
-	var secret string
+	var plaintext string
 	if ctx.Bool("masked_value") {
 		ui := &input.UI{
-			Writer: os.Stdout,
+			Writer: os.Stderr,
 			Reader: os.Stdin,
 		}
 
@@ -56,17 +87,18 @@ func SecretWrite(ctx *cli.Context) (int, error) {
 			return 1, err
 		}
 
-		secret = v.Cipher(value)
+		plaintext = value
 	} else if ctx.String("value") == "-" {
 		read, err := ioutil.ReadAll(os.Stdin)
 		if err != nil {
 			return 1, err
 		}
-		secret = v.Cipher(string(read))
+		plaintext = string(read)
 	} else if ctx.String("value") != "" {
-		secret = v.Cipher(ctx.String("value"))
+		plaintext = ctx.String("value")
 	} else if ctx.Int("random") != 0 {
-		secret = v.Cipher(rand.String(ctx.Int("random")))
+		plaintext = rand.String(ctx.Int("random"))
+		secret.Generator = fmt.Sprintf("random:%d", ctx.Int("random"))
 	} else {
 		if err := cli.ShowSubcommandHelp(ctx); err != nil {
 			return 1, err
@@ -74,21 +106,47 @@ func SecretWrite(ctx *cli.Context) (int, error) {
 		return 1, nil
 	}
 
+	if ctx.Bool("json") {
+		if _, err := decodeValue(plaintext, valueTypeJSON); err != nil {
+			return 1, err
+		}
+		secret.Type = valueTypeJSON
+	}
+	secret.Value = v.Cipher(ctx.String("secret"), ctx.String("key"), plaintext)
+
 	s.WriteSecretKey(ctx.String("secret"), ctx.String("key"), secret)
 
 	return 0, nil
 }
 
+// validateSecretName : Ensures that a secret name can be used as a Vault KV path,
+// nested secrets are separated by '/' (eg: app/env/db)
+func validateSecretName(name string) error {
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid secret name '%v', it must be made of non-empty '/' separated segments", name)
+		}
+	}
+	return nil
+}
+
 // SecretList ..
 func SecretList(ctx *cli.Context) (int, error) {
+	sel, err := selectorFromContext(ctx)
+	if err != nil {
+		return 1, err
+	}
+
 	s.Load()
-	switch ctx.NArg() {
-	case 1:
-		s.ListSecrets(ctx.Args().First())
-	default:
-		s.ListSecrets("")
+	r, err := s.ListSecrets(ctx.Args().First(), sel)
+	if err != nil {
+		return 1, err
 	}
+	r.withMetadata = ctx.Bool("metadata")
 
+	if err = render(r); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
@@ -100,7 +158,7 @@ func SecretDelete(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
+	s.LoadForUpdate()
 
 	if ctx.String("key") == "" {
 		s.DeleteSecret(ctx.String("secret"))
@@ -111,6 +169,23 @@ func SecretDelete(ctx *cli.Context) (int, error) {
 	return 0, nil
 }
 
+// SecretRewrap ..
+func SecretRewrap(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 0 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := render(s.RewrapSecrets(ctx.Bool("min-decryption-version"))); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
 // SecretRotateFrom ..
 func SecretRotateFrom(ctx *cli.Context) (int, error) {
 	if ctx.NArg() != 1 {
@@ -119,8 +194,25 @@ func SecretRotateFrom(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
-	s.RotateFromOldTransitKey(ctx.Args().First())
+	sel, err := selectorFromContext(ctx)
+	if err != nil {
+		return 1, err
+	}
+
+	if ctx.Bool("dry-run") {
+		s.Load()
+	} else {
+		s.LoadForUpdate()
+	}
+
+	r := s.RotateFromOldTransitKey(ctx.Args().First(), sel, ctx.Bool("dry-run"))
+	if err = render(r); err != nil {
+		return 1, err
+	}
+
+	if r.Failed > 0 {
+		return 1, fmt.Errorf("%v value(s) could not be deciphered using transit key '%v'", r.Failed, r.From)
+	}
 
 	return 0, nil
 }
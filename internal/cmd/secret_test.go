@@ -1,3 +1,17 @@
 package cmd
 
-// TODO: Implement
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSecretName(t *testing.T) {
+	assert.NoError(t, validateSecretName("foo"))
+	assert.NoError(t, validateSecretName("app/env/db"))
+	assert.Error(t, validateSecretName(""))
+	assert.Error(t, validateSecretName("/foo"))
+	assert.Error(t, validateSecretName("foo/"))
+	assert.Error(t, validateSecretName("foo//bar"))
+	assert.Error(t, validateSecretName("foo/../bar"))
+}
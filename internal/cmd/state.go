@@ -1,12 +1,19 @@
 package cmd
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	log "github.com/sirupsen/logrus"
@@ -15,17 +22,77 @@ import (
 
 // State : Handles state information
 type State struct {
+	// Version of the statefile schema
+	Version int `yaml:"version"`
+
+	// Default target, defined at the root of the statefile
+	Default Target `yaml:",inline"`
+
+	// Targets are additional named targets
+	Targets map[string]*Target `yaml:"targets,omitempty"`
+
+	Config *StateConfig `yaml:"-"`
+
+	// selected is the name of the target the commands are applied onto, all the
+	// targets are considered by plan and apply when it is empty
+	selected string
+
+	// loadedVersion is the schema version the statefile has been written with
+	loadedVersion int
+}
+
+// Target : A Vault KV mount and transit key, alongside the secrets stored into it
+type Target struct {
 	Vault struct {
-		TransitKey string
-		KV         struct {
-			Path    string
-			Version int
+		TransitKey  string
+		TransitKeys []TransitKeyRule `yaml:"transitkeys,omitempty"`
+		KV          struct {
+			Path       string
+			Version    int
+			DeleteMode string   `yaml:"deletemode,omitempty"`
+			Ownership  string   `yaml:"ownership,omitempty"`
+			Ignore     []string `yaml:"ignore,omitempty"`
+			Merge      []string `yaml:"merge,omitempty"`
+			SyncLabels bool     `yaml:"synclabels,omitempty"`
 		}
 	}
-	Secrets map[string]map[string]string
-	Config  *StateConfig `yaml:"-"`
+	Secrets map[string]map[string]SecretKey `yaml:"secrets,omitempty"`
+
+	// Labels of the secrets, indexed by their names
+	Labels map[string]map[string]string `yaml:"labels,omitempty"`
+}
+
+// validate : Returns an error if the KV path or version of the target are not defined,
+// they are not assumed anymore since the schema is versioned
+func (t *Target) validate(name string) error {
+	if t.Vault.KV.Path == "" {
+		return fmt.Errorf("target '%v' does not define its KV path, it has to be set in the state file (vault.kv.path)", name)
+	}
+
+	if t.Vault.KV.Version != 1 && t.Vault.KV.Version != 2 {
+		return fmt.Errorf("target '%v' does not define a valid KV version (got %d), it has to be set to 1 or 2 in the state file (vault.kv.version)", name, t.Vault.KV.Version)
+	}
+	return nil
+}
+
+// TransitKeyRule : TransitKey to use for the secrets, or secret keys, matching a
+// pattern, formatted as <secret> or <secret>:<key>
+type TransitKeyRule struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Key     string `json:"key" yaml:"key"`
+}
+
+// matches : Returns true if the rule applies to the key of the secret
+func (r TransitKeyRule) matches(secret, key string) bool {
+	if parts := strings.SplitN(r.Pattern, ":", 2); len(parts) == 2 {
+		return matchPattern(parts[0], secret) && matchPattern(parts[1], key)
+	}
+	return matchPattern(r.Pattern, secret)
 }
 
+// defaultTarget : Name of the target defined at the root of the statefile
+const defaultTarget = "default"
+
 // StateConfig handles state client configuration
 type StateConfig struct {
 	Path string
@@ -46,46 +113,429 @@ func (s *State) Init() {
 	s.save()
 }
 
+// SelectTarget : Selects the target onto which the commands are applied
+func (s *State) SelectTarget(name string) {
+	s.selected = name
+}
+
+// target : Returns the currently selected target
+func (s *State) target() *Target {
+	if s.selected == "" || s.selected == defaultTarget {
+		return &s.Default
+	}
+
+	t, found := s.Targets[s.selected]
+	if !found {
+		log.Fatalf("Target '%v' not found in the statefile, use 'strongbox target add %v' to create it", s.selected, s.selected)
+	}
+	return t
+}
+
+// TargetName : Returns the name of the currently selected target
+func (s *State) TargetName() string {
+	if s.selected == "" {
+		return defaultTarget
+	}
+	return s.selected
+}
+
+// TargetNames : Returns the names of all the targets, starting with the default one
+func (s *State) TargetNames() []string {
+	return append([]string{defaultTarget}, sortedKeys(s.Targets)...)
+}
+
+// selectedTargetNames : Returns the name of the selected target if any, the names of
+// all the targets otherwise
+func (s *State) selectedTargetNames() []string {
+	if s.selected != "" {
+		return []string{s.selected}
+	}
+	return s.TargetNames()
+}
+
+// AddTarget : Adds a new named target to the statefile
+func (s *State) AddTarget(name string) error {
+	if name == defaultTarget {
+		return fmt.Errorf("'%v' is reserved for the target defined at the root of the statefile", defaultTarget)
+	}
+
+	if _, found := s.Targets[name]; found {
+		return fmt.Errorf("target '%v' already exists", name)
+	}
+
+	if s.Targets == nil {
+		s.Targets = make(map[string]*Target)
+	}
+
+	t := &Target{}
+	t.Vault.TransitKey = s.Default.Vault.TransitKey
+	t.Vault.KV.Path = "secret/"
+	t.Vault.KV.Version = 2
+	if err := t.validate(name); err != nil {
+		return err
+	}
+
+	s.Targets[name] = t
+	s.save()
+	return nil
+}
+
+// RemoveTarget : Removes a named target and all its secrets from the statefile
+func (s *State) RemoveTarget(name string) error {
+	if _, found := s.Targets[name]; !found {
+		return fmt.Errorf("target '%v' not found", name)
+	}
+
+	delete(s.Targets, name)
+	s.save()
+	return nil
+}
+
 // SetVaultTransitKey : Update state file with a Vault/TransitKey value
 func (s *State) SetVaultTransitKey(value string) {
-	s.Vault.TransitKey = value
+	s.target().Vault.TransitKey = value
 	s.save()
 }
 
 // VaultTransitKey : Returns the value of the configured Vault/TransitKey
 func (s *State) VaultTransitKey() string {
-	return s.Vault.TransitKey
+	return s.target().Vault.TransitKey
+}
+
+// VaultTransitKeys : Returns the TransitKey rules of the selected target
+func (s *State) VaultTransitKeys() []TransitKeyRule {
+	return s.target().Vault.TransitKeys
+}
+
+// VaultTransitKeyFor : Returns the TransitKey used to cipher the key of a secret, the
+// first matching rule wins and the TransitKey of the target is used if none matches
+func (s *State) VaultTransitKeyFor(secret, key string) string {
+	return transitKeyFor(s.VaultTransitKeys(), s.VaultTransitKey(), secret, key)
+}
+
+func transitKeyFor(rules []TransitKeyRule, defaultKey, secret, key string) string {
+	for _, r := range rules {
+		if r.matches(secret, key) {
+			return r.Key
+		}
+	}
+	return defaultKey
+}
+
+// AssignVaultTransitKey : Assigns a TransitKey to the secrets and keys matching the
+// pattern, the values which are affected get ciphered again using their new TransitKey
+func (s *State) AssignVaultTransitKey(pattern, key string) (int, error) {
+	for _, p := range strings.SplitN(pattern, ":", 2) {
+		if _, err := path.Match(p, ""); err != nil {
+			return 0, fmt.Errorf("invalid pattern '%v': %v", pattern, err)
+		}
+	}
+
+	rules := append([]TransitKeyRule{}, s.VaultTransitKeys()...)
+	found := false
+	for i := range rules {
+		if rules[i].Pattern == pattern {
+			rules[i].Key = key
+			found = true
+		}
+	}
+
+	if !found {
+		rules = append(rules, TransitKeyRule{Pattern: pattern, Key: key})
+	}
+
+	return s.setVaultTransitKeys(rules)
+}
+
+// UnassignVaultTransitKey : Removes the TransitKey rule of a pattern, the values which
+// are affected get ciphered again using their new TransitKey
+func (s *State) UnassignVaultTransitKey(pattern string) (int, error) {
+	var rules []TransitKeyRule
+	for _, r := range s.VaultTransitKeys() {
+		if r.Pattern != pattern {
+			rules = append(rules, r)
+		}
+	}
+
+	if len(rules) == len(s.VaultTransitKeys()) {
+		return 0, fmt.Errorf("no transit key assigned to '%v'", pattern)
+	}
+
+	return s.setVaultTransitKeys(rules)
+}
+
+// setVaultTransitKeys : Replaces the TransitKey rules and ciphers again the values whose
+// TransitKey has changed, returns the amount of values which have been updated
+func (s *State) setVaultTransitKeys(rules []TransitKeyRule) (int, error) {
+	// All the affected values are deciphered before updating any of them
+	t := s.target()
+	var items []*transitItem
+	for _, secret := range sortedKeys(t.Secrets) {
+		for _, key := range sortedKeys(t.Secrets[secret]) {
+			if s.VaultTransitKeyFor(secret, key) == transitKeyFor(rules, s.VaultTransitKey(), secret, key) {
+				continue
+			}
+			items = append(items, &transitItem{TransitKey: s.VaultTransitKeyFor(secret, key), Secret: secret, Key: key, Input: t.Secrets[secret][key].Value})
+		}
+	}
+
+	v.transitBatch(transitDecrypt, items)
+	for _, item := range items {
+		if item.Err != nil {
+			return 0, fmt.Errorf("%v:%v: %v", item.Secret, item.Key, item.Err)
+		}
+		item.TransitKey, item.Input = transitKeyFor(rules, s.VaultTransitKey(), item.Secret, item.Key), item.Output
+	}
+
+	t.Vault.TransitKeys = rules
+	v.transitBatch(transitEncrypt, items)
+	count := 0
+	for _, item := range items {
+		if item.Err != nil {
+			log.Fatalf("unable to cipher %v:%v: %v", item.Secret, item.Key, item.Err)
+		}
+
+		value := t.Secrets[item.Secret][item.Key]
+		value.Value = item.Output
+		t.Secrets[item.Secret][item.Key] = value
+		count++
+	}
+
+	s.save()
+	return count, nil
 }
 
 // SetVaultKVPath : Update state file with a Vault/Secret/Path value
 func (s *State) SetVaultKVPath(value string) {
-	s.Vault.KV.Path = value
+	s.target().Vault.KV.Path = value
 	s.save()
 }
 
 // VaultKVPath : Returns the value of the configured Vault/Secret/Path
 func (s *State) VaultKVPath() string {
-	if s.Vault.KV.Path == "" {
-		return "secret/"
-	}
-	return s.Vault.KV.Path
+	return s.target().Vault.KV.Path
 }
 
 // SetVaultKVVersion : Update state file with a Vault/Secret/Version value
 func (s *State) SetVaultKVVersion(version int) {
-	s.Vault.KV.Version = version
+	s.target().Vault.KV.Version = version
 	s.save()
 }
 
 // VaultKVVersion : Returns the value of the configured Vault/Secret/Version
 func (s *State) VaultKVVersion() int {
-	if s.Vault.KV.Version == 0 {
-		return 1
+	return s.target().Vault.KV.Version
+}
+
+// SetVaultKVDeleteMode : Update state file with a Vault/Secret/DeleteMode value
+func (s *State) SetVaultKVDeleteMode(mode string) {
+	s.target().Vault.KV.DeleteMode = mode
+	s.save()
+}
+
+// VaultKVDeleteMode : Returns the value of the configured Vault/Secret/DeleteMode
+func (s *State) VaultKVDeleteMode() string {
+	if s.target().Vault.KV.DeleteMode == "" {
+		return deleteModeSoft
+	}
+	return s.target().Vault.KV.DeleteMode
+}
+
+// Secrets ownership modes
+const (
+	// ownershipAll : every secret stored under the KV path is managed by strongbox
+	ownershipAll = "all"
+
+	// ownershipMarked : only the secrets marked with the strongbox custom metadata are
+	// managed by strongbox, requires KV v2
+	ownershipMarked = "marked"
+)
+
+// SetVaultKVOwnership : Update state file with a Vault/Secret/Ownership value
+func (s *State) SetVaultKVOwnership(ownership string) {
+	s.target().Vault.KV.Ownership = ownership
+	s.save()
+}
+
+// VaultKVOwnership : Returns the value of the configured Vault/Secret/Ownership
+func (s *State) VaultKVOwnership() string {
+	if s.target().Vault.KV.Ownership == "" {
+		return ownershipAll
+	}
+	return s.target().Vault.KV.Ownership
+}
+
+// SetVaultKVSyncLabels : Update state file with a Vault/Secret/SyncLabels value
+func (s *State) SetVaultKVSyncLabels(sync bool) {
+	s.target().Vault.KV.SyncLabels = sync
+	s.save()
+}
+
+// VaultKVSyncLabels : Returns true if the labels of the secrets get written into their
+// KV v2 custom metadata
+func (s *State) VaultKVSyncLabels() bool {
+	return s.target().Vault.KV.SyncLabels
+}
+
+// AddVaultKVIgnore : Add a pattern to the Vault/Secret/Ignore list
+func (s *State) AddVaultKVIgnore(pattern string) error {
+	if err := addPattern(&s.target().Vault.KV.Ignore, pattern); err != nil {
+		return err
+	}
+	s.save()
+	return nil
+}
+
+// RemoveVaultKVIgnore : Remove a pattern from the Vault/Secret/Ignore list
+func (s *State) RemoveVaultKVIgnore(pattern string) error {
+	if err := removePattern(&s.target().Vault.KV.Ignore, pattern); err != nil {
+		return err
+	}
+	s.save()
+	return nil
+}
+
+// VaultKVIgnore : Returns the value of the configured Vault/Secret/Ignore
+func (s *State) VaultKVIgnore() []string {
+	return s.target().Vault.KV.Ignore
+}
+
+// isIgnored : Returns true if the secret matches one of the ignored patterns
+func (s *State) isIgnored(secret string) bool {
+	return matchesAny(s.VaultKVIgnore(), secret)
+}
+
+// AddVaultKVMerge : Add a pattern to the Vault/Secret/Merge list
+func (s *State) AddVaultKVMerge(pattern string) error {
+	if err := addPattern(&s.target().Vault.KV.Merge, pattern); err != nil {
+		return err
+	}
+	s.save()
+	return nil
+}
+
+// RemoveVaultKVMerge : Remove a pattern from the Vault/Secret/Merge list
+func (s *State) RemoveVaultKVMerge(pattern string) error {
+	if err := removePattern(&s.target().Vault.KV.Merge, pattern); err != nil {
+		return err
+	}
+	s.save()
+	return nil
+}
+
+// VaultKVMerge : Returns the value of the configured Vault/Secret/Merge
+func (s *State) VaultKVMerge() []string {
+	return s.target().Vault.KV.Merge
+}
+
+// isMerged : Returns true if the secret matches one of the merge patterns, strongbox
+// then only manages the keys of the secret which are defined in the statefile
+func (s *State) isMerged(secret string) bool {
+	return matchesAny(s.VaultKVMerge(), secret)
+}
+
+// addPattern : Add a glob pattern to a sorted list of patterns
+func addPattern(patterns *[]string, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern '%v': %v", pattern, err)
+	}
+
+	for _, p := range *patterns {
+		if p == pattern {
+			return nil
+		}
+	}
+
+	*patterns = append(*patterns, pattern)
+	sort.Strings(*patterns)
+	return nil
+}
+
+// removePattern : Remove a glob pattern from a list of patterns
+func removePattern(patterns *[]string, pattern string) error {
+	for i, p := range *patterns {
+		if p == pattern {
+			*patterns = append((*patterns)[:i], (*patterns)[i+1:]...)
+			return nil
+		}
 	}
-	return s.Vault.KV.Version
+	return fmt.Errorf("pattern '%v' is not in the list", pattern)
 }
 
-// Load : Loads the statefile content in memory
+// matchesAny : Returns true if the name matches at least one of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern : Returns true if the name matches the glob pattern. On top of the
+// path.Match syntax, '**' matches any sequence of characters including '/'
+func matchPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+
+	// Each part in between '**' has to match a sequence of path segments
+	parts := strings.Split(pattern, "**")
+	expr := "^"
+	for i, part := range parts {
+		if i > 0 {
+			expr += ".*"
+		}
+		expr += globToRegexp(part)
+	}
+
+	matched, _ := regexp.MatchString(expr+"$", name)
+	return matched
+}
+
+// globToRegexp : Converts a path.Match pattern into a regular expression
+func globToRegexp(pattern string) (expr string) {
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr += "[^/]*"
+		case '?':
+			expr += "[^/]"
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return expr + regexp.QuoteMeta(pattern[i:])
+			}
+			expr += pattern[i : i+end+1]
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				expr += regexp.QuoteMeta(string(pattern[i]))
+			}
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return
+}
+
+// LoadForUpdate : Loads the statefile content in memory while holding its exclusive lock,
+// for the commands which update it. Other processes cannot use it until they complete
+func (s *State) LoadForUpdate() {
+	if s.Config.Path == "" {
+		log.Fatal("State file must be defined")
+	}
+
+	if err := lockState(s.Config.Path, lockExclusive); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	s.Load()
+}
+
+// Load : Loads the statefile content in memory, holding a shared lock onto it so that
+// it cannot be updated by another process meanwhile
 func (s *State) Load() {
 	if s.Config.Path == "" {
 		log.Fatal("State file must be defined")
@@ -96,163 +546,484 @@ func (s *State) Load() {
 		log.Fatalf("State file not found at location: %s, use 'strongbox init' to generate an empty one.\n", s.Config.Path)
 	}
 
+	if err := lockState(s.Config.Path, lockShared); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
 	filename, _ := filepath.Abs(s.Config.Path)
+	var files map[string][]byte
+	if s.layout() == layoutDirectory {
+		var err error
+		if files, err = readDirectory(filename); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+		if _, found := files[stateIndexFile]; !found {
+			log.Fatalf("Error: %v not found in the state directory %v", stateIndexFile, s.Config.Path)
+		}
+		filename = filepath.Join(filename, stateIndexFile)
+	}
+
 	data, err := ioutil.ReadFile(filepath.Clean(filename))
 	if err != nil {
 		log.Fatal("Error: State file not found, create a new one using : 'strongbox init'")
 	}
 
+	data, s.loadedVersion, err = migrateState(data)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if s.loadedVersion < stateVersion {
+		log.Infof("State file upgraded in memory from schema version %d to %d, use 'strongbox state migrate' to save it", s.loadedVersion, stateVersion)
+	}
+
 	err = yaml.Unmarshal(data, &s)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 
+	if files != nil {
+		if err = s.loadDirectory(files); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if err = s.Default.validate(defaultTarget); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	for _, name := range sortedKeys(s.Targets) {
+		if err = s.Targets[name].validate(name); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	log.Debugf("Loaded Target: %v", s.TargetName())
 	log.Debugf("Loaded Transit Key: %v", s.VaultTransitKey())
 	log.Debugf("Loaded KV Path: %v", s.VaultKVPath())
 	log.Debugf("Loaded KV Version: %v", s.VaultKVVersion())
-	log.Debugf("Loaded Secrets: %#v", s.Secrets)
+	log.Debugf("Loaded Secrets: %#v", s.target().Secrets)
+}
+
+// Fingerprint : Returns a checksum of the statefile content as it is stored on disk
+func (s *State) Fingerprint() (string, error) {
+	filename, err := filepath.Abs(s.Config.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if s.layout() == layoutDirectory {
+		files, err := readDirectory(filename)
+		if err != nil {
+			return "", err
+		}
+		return fingerprintFiles(files), nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// StateStatus : Information about the statefile content
+type StateStatus struct {
+	Version      int              `json:"version" yaml:"version"`
+	Target       string           `json:"target" yaml:"target"`
+	Targets      []string         `json:"targets" yaml:"targets"`
+	TransitKey   string           `json:"transit_key" yaml:"transit_key"`
+	TransitKeys  []TransitKeyRule `json:"transit_keys" yaml:"transit_keys"`
+	KVPath       string           `json:"kv_path" yaml:"kv_path"`
+	KVVersion    int              `json:"kv_version" yaml:"kv_version"`
+	Ownership    string           `json:"ownership" yaml:"ownership"`
+	Ignore       []string         `json:"ignore" yaml:"ignore"`
+	Merge        []string         `json:"merge" yaml:"merge"`
+	SyncLabels   bool             `json:"sync_labels" yaml:"sync_labels"`
+	SecretsCount int              `json:"secrets_count" yaml:"secrets_count"`
 }
 
 // Status : Returns information about statefile content
-func (s *State) Status() {
-	fmt.Println("[STRONGBOX STATE]")
-	table := tablewriter.NewWriter(os.Stdout)
-	table.Append([]string{"Transit Key", s.VaultTransitKey()})
-	table.Append([]string{"KV Path", s.VaultKVPath()})
-	table.Append([]string{"KV Version", strconv.Itoa(s.VaultKVVersion())})
-	table.Append([]string{"Secrets #", fmt.Sprintf("%v", len(s.Secrets))})
+func (s *State) Status() StateStatus {
+	return StateStatus{
+		Version:      s.Version,
+		Target:       s.TargetName(),
+		Targets:      s.TargetNames(),
+		TransitKey:   s.VaultTransitKey(),
+		TransitKeys:  append([]TransitKeyRule{}, s.VaultTransitKeys()...),
+		KVPath:       s.VaultKVPath(),
+		KVVersion:    s.VaultKVVersion(),
+		Ownership:    s.VaultKVOwnership(),
+		Ignore:       append([]string{}, s.VaultKVIgnore()...),
+		Merge:        append([]string{}, s.VaultKVMerge()...),
+		SyncLabels:   s.VaultKVSyncLabels(),
+		SecretsCount: len(s.target().Secrets),
+	}
+}
+
+func (ss StateStatus) renderTable(w io.Writer) {
+	fmt.Fprintln(w, "[STRONGBOX STATE]")
+	table := tablewriter.NewWriter(w)
+	table.Append([]string{"Schema Version", strconv.Itoa(ss.Version)})
+	table.Append([]string{"Target", ss.Target})
+	table.Append([]string{"Targets", strings.Join(ss.Targets, ", ")})
+	table.Append([]string{"Transit Key", ss.TransitKey})
+	for _, r := range ss.TransitKeys {
+		table.Append([]string{"Transit Key", fmt.Sprintf("%v (%v)", r.Key, r.Pattern)})
+	}
+	table.Append([]string{"KV Path", ss.KVPath})
+	table.Append([]string{"KV Version", strconv.Itoa(ss.KVVersion)})
+	table.Append([]string{"Ownership", ss.Ownership})
+	table.Append([]string{"Ignored", strings.Join(ss.Ignore, ", ")})
+	table.Append([]string{"Merged", strings.Join(ss.Merge, ", ")})
+	table.Append([]string{"Sync Labels", strconv.FormatBool(ss.SyncLabels)})
+	table.Append([]string{"Secrets #", fmt.Sprintf("%v", ss.SecretsCount)})
 	table.Render()
 }
 
-// ListSecrets : List the secrets, safely stored into the statefile
-func (s *State) ListSecrets(secret string) {
-	log.Debug("Rendering local secrets list")
+// SecretListResult : Ciphered secrets stored into the statefile
+type SecretListResult struct {
+	Secrets map[string]map[string]SecretKey `json:"secrets" yaml:"secrets"`
+	Labels  map[string]map[string]string    `json:"labels,omitempty" yaml:"labels,omitempty"`
 
-	if secret == "" {
-		for k, l := range s.Secrets {
-			table := tablewriter.NewWriter(os.Stdout)
-			fmt.Printf("[%v]\n", k)
-			for m, n := range l {
-				table.Append([]string{m, n})
-			}
-			table.Render()
+	// withMetadata renders the metadata of the keys instead of their ciphertexts
+	withMetadata bool
+}
+
+// ListSecrets : List the secrets, safely stored into the statefile, which match the selector
+func (s *State) ListSecrets(secret string, sel selector) (*SecretListResult, error) {
+	log.Debug("Listing local secrets")
+
+	if secret != "" && s.target().Secrets[secret] == nil {
+		return nil, fmt.Errorf("no secret '%v' found", secret)
+	}
+
+	r := &SecretListResult{
+		Secrets: make(map[string]map[string]SecretKey),
+		Labels:  make(map[string]map[string]string),
+	}
+
+	for name, keys := range s.target().Secrets {
+		if (secret != "" && name != secret) || !s.selectedBy(sel)(name) {
+			continue
 		}
-	} else {
-		if s.Secrets[secret] == nil {
-			fmt.Printf("No secret '%v' found\n", secret)
-			os.Exit(1)
+
+		r.Secrets[name] = keys
+		if labels := s.SecretLabels(name); len(labels) > 0 {
+			r.Labels[name] = labels
 		}
+	}
+
+	return r, nil
+}
+
+func (r *SecretListResult) renderTable(w io.Writer) {
+	for _, k := range sortedKeys(r.Secrets) {
+		if labels := r.Labels[k]; len(labels) > 0 {
+			fmt.Fprintf(w, "[%v] %v\n", k, formatLabels(labels))
+		} else {
+			fmt.Fprintf(w, "[%v]\n", k)
+		}
+		table := tablewriter.NewWriter(w)
+		if r.withMetadata {
+			table.SetHeader([]string{"Key", "Description", "Owner", "Created", "Updated", "Generator"})
+		}
+
+		for _, m := range sortedKeys(r.Secrets[k]) {
+			value := r.Secrets[k][m]
+			name := m
+			if t := value.valueType(); t != valueTypeString {
+				name = fmt.Sprintf("%v (%v)", m, t)
+			}
 
-		table := tablewriter.NewWriter(os.Stdout)
-		for k, l := range s.Secrets[secret] {
-			table.Append([]string{k, l})
+			if r.withMetadata {
+				table.Append([]string{name, value.Description, value.Owner, formatTimestamp(value.CreatedAt), formatTimestamp(value.UpdatedAt), value.Generator})
+				continue
+			}
+			table.Append([]string{name, value.Value})
 		}
 		table.Render()
 	}
 }
 
-// WriteSecretKey : Add or Update a key value within a secret
-func (s *State) WriteSecretKey(secret, key, value string) {
-	if s.Secrets == nil {
-		s.Secrets = map[string]map[string]string{}
+// formatTimestamp : Returns the RFC3339 representation of a timestamp, if defined
+func formatTimestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// WriteSecretKey : Add or Update a key value within a secret, its metadata is carried
+// over from the previous value unless they are defined and its timestamps get updated
+func (s *State) WriteSecretKey(secret, key string, value SecretKey) {
+	s.setSecretKey(secret, key, s.target().updatedSecretKey(secret, key, value))
+}
+
+// setSecretKey : Add or Replace a key within a secret, as is
+func (s *State) setSecretKey(secret, key string, value SecretKey) {
+	t := s.target()
+	if t.Secrets == nil {
+		t.Secrets = map[string]map[string]SecretKey{}
 	}
 
-	if s.Secrets[secret] == nil {
-		s.Secrets[secret] = map[string]string{}
+	if t.Secrets[secret] == nil {
+		t.Secrets[secret] = map[string]SecretKey{}
 	}
 
-	s.Secrets[secret][key] = value
+	t.Secrets[secret][key] = value
 	s.save()
 }
 
+// SetSecret : Add or Replace a secret and all its keys
+func (s *State) SetSecret(secret string, keys map[string]SecretKey) {
+	s.setSecret(secret, keys)
+	s.save()
+}
+
+// SetSecrets : Add or Replace several secrets and all their keys, the statefile is only
+// saved once all of them have been set
+func (s *State) SetSecrets(secrets map[string]map[string]SecretKey) {
+	for _, secret := range sortedKeys(secrets) {
+		s.setSecret(secret, secrets[secret])
+	}
+	s.save()
+}
+
+// setSecret : Add or Replace a secret and all its keys, without saving the statefile
+func (s *State) setSecret(secret string, keys map[string]SecretKey) {
+	if err := validateSecretName(secret); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	t := s.target()
+	if t.Secrets == nil {
+		t.Secrets = map[string]map[string]SecretKey{}
+	}
+
+	for key, value := range keys {
+		keys[key] = t.updatedSecretKey(secret, key, value)
+	}
+
+	t.Secrets[secret] = keys
+}
+
+// updatedSecretKey : Returns the new version of a key of the target, the description and
+// owner of the previous version are kept unless they are redefined
+func (t *Target) updatedSecretKey(secret, key string, value SecretKey) SecretKey {
+	now := time.Now().UTC().Truncate(time.Second)
+	previous, found := t.Secrets[secret][key]
+	if !found {
+		value.CreatedAt = &now
+	} else {
+		value.CreatedAt = previous.CreatedAt
+		if value.Description == "" {
+			value.Description = previous.Description
+		}
+
+		if value.Owner == "" {
+			value.Owner = previous.Owner
+		}
+	}
+
+	value.UpdatedAt = &now
+	return value
+}
+
 // ReadSecretKey : Read the value of a SecretKey
-func (s *State) ReadSecretKey(secret, key string) string {
-	if s.Secrets == nil || s.Secrets[secret] == nil {
-		fmt.Printf("No secret '%v' found\n", secret)
-		os.Exit(1)
+func (s *State) ReadSecretKey(secret, key string) SecretKey {
+	t := s.target()
+	if t.Secrets == nil || t.Secrets[secret] == nil {
+		log.Fatalf("No secret '%v' found", secret)
 	}
 
-	if s.Secrets[secret][key] == "" {
-		fmt.Printf("No key '%v' found in secret '%v'\n", key, secret)
-		os.Exit(1)
+	if _, found := t.Secrets[secret][key]; !found {
+		log.Fatalf("No key '%v' found in secret '%v'", key, secret)
 	}
 
-	return s.Secrets[secret][key]
+	return t.Secrets[secret][key]
 }
 
 // DeleteSecret : Delete a secret from the statefile based on its name
 func (s *State) DeleteSecret(secret string) {
-	if s.Secrets == nil || s.Secrets[secret] == nil {
-		fmt.Printf("No secret '%v' found\n", secret)
-		os.Exit(1)
+	t := s.target()
+	if t.Secrets == nil || t.Secrets[secret] == nil {
+		log.Fatalf("No secret '%v' found", secret)
 	}
 
-	delete(s.Secrets, secret)
+	delete(t.Secrets, secret)
+	delete(t.Labels, secret)
 	s.save()
-	fmt.Println("Secret deleted!")
+	log.Infof("Secret '%v' deleted", secret)
 }
 
 // DeleteSecretKey : Delete a secret:key from the statefile based on the secret and key names
 func (s *State) DeleteSecretKey(secret, key string) {
-	if s.Secrets == nil || s.Secrets[secret] == nil {
-		fmt.Printf("No secret '%v' found\n", secret)
-		os.Exit(1)
+	t := s.target()
+	if t.Secrets == nil || t.Secrets[secret] == nil {
+		log.Fatalf("No secret '%v' found", secret)
 	}
 
-	if s.Secrets[secret][key] == "" {
-		fmt.Printf("No key '%v' found in secret '%v'\n", key, secret)
-		os.Exit(1)
+	if _, found := t.Secrets[secret][key]; !found {
+		log.Fatalf("No key '%v' found in secret '%v'", key, secret)
 	}
 
-	delete(s.Secrets[secret], key)
+	delete(t.Secrets[secret], key)
 	s.save()
-	fmt.Println("Key deleted!")
+	log.Infof("Key '%v' deleted from secret '%v'", key, secret)
 }
 
-// RotateFromOldTransitKey : Replace locally ciphered values with new transit key
-func (s *State) RotateFromOldTransitKey(key string) {
+// RotateFromOldTransitKey : Replace locally ciphered values of the secrets matching the
+// selector with new transit key. Every value is re-ciphered before the statefile gets
+// saved once, the values which have been re-ciphered are journaled meanwhile so that an
+// interrupted rotation can be resumed
+func (s *State) RotateFromOldTransitKey(key string, sel selector, dryRun bool) SecretRotateFromResult {
 	transitKey := s.VaultTransitKey()
 	if transitKey == key {
 		log.Fatalf("%v is already the currently configured key, can't rotate with same key", key)
 	}
 
-	s.SetVaultTransitKey(key)
-	secrets := make(map[string]map[string]string)
-	for k, l := range s.Secrets {
-		if secrets[k] == nil {
-			secrets[k] = make(map[string]string)
+	path, err := rotationJournalPath(s.Config.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	journal, err := readRotationJournal(path, rotationJournalHeader{Target: s.TargetName(), From: key, To: transitKey})
+	if err != nil {
+		log.Fatalf("Error: unable to read the rotation journal: %v", err)
+	}
+
+	if !dryRun {
+		if err = journal.open(); err != nil {
+			log.Fatalf("Error: unable to write the rotation journal: %v", err)
 		}
-		for m, n := range l {
-			secrets[k][m] = v.Decipher(n)
+	}
+
+	// Values which have a specific TransitKey assigned are not affected, the ones which
+	// cannot be deciphered using the current token are left untouched
+	r := SecretRotateFromResult{From: key, To: transitKey, DryRun: dryRun}
+	t := s.target()
+	rotated := make(map[string]map[string]string)
+	setRotated := func(secret, key, value string) {
+		if rotated[secret] == nil {
+			rotated[secret] = make(map[string]string)
+		}
+		rotated[secret][key] = value
+		r.Rotated++
+	}
+
+	var pending []*transitItem
+	for _, k := range sortedKeys(t.Secrets) {
+		if !s.selectedBy(sel)(k) {
+			continue
+		}
+
+		for _, m := range sortedKeys(t.Secrets[k]) {
+			if s.VaultTransitKeyFor(k, m) != transitKey {
+				continue
+			}
+
+			current := t.Secrets[k][m].Value
+			if value, resumed := journal.lookup("", k, m, current); resumed {
+				r.Resumed++
+				setRotated(k, m, value)
+				continue
+			}
+			pending = append(pending, &transitItem{TransitKey: key, Secret: k, Key: m, Input: current})
+		}
+	}
+
+	// The values are re-ciphered in batches, which get journaled once they are done. A dry
+	// run only deciphers them, to report the ones which would actually be rotated
+	for _, batch := range transitBatches(pending) {
+		v.transitBatch(transitDecrypt, batch)
+
+		var plaintexts []*transitItem
+		for _, item := range batch {
+			switch {
+			case isInaccessible(item.Err):
+				log.Warnf("Skipping %v:%v, %v", item.Secret, item.Key, item.Err)
+				r.Inaccessible++
+			case item.Err != nil && dryRun:
+				log.Errorf("Unable to decipher %v:%v: %v", item.Secret, item.Key, item.Err)
+				r.Failed++
+			case item.Err != nil:
+				log.Fatalf("Error: unable to decipher %v:%v: %v", item.Secret, item.Key, item.Err)
+			case dryRun:
+				r.Rotated++
+			default:
+				plaintexts = append(plaintexts, &transitItem{TransitKey: transitKey, Secret: item.Secret, Key: item.Key, Input: item.Output})
+			}
+		}
+
+		if dryRun {
+			continue
+		}
+
+		v.transitBatch(transitEncrypt, plaintexts)
+		for _, item := range plaintexts {
+			if item.Err != nil {
+				log.Fatalf("unable to cipher %v:%v: %v", item.Secret, item.Key, item.Err)
+			}
+
+			if err = journal.record(rotationJournalEntry{Secret: item.Secret, Key: item.Key, Previous: t.Secrets[item.Secret][item.Key].Value, Value: item.Output}); err != nil {
+				log.Fatalf("Error: unable to write the rotation journal: %v", err)
+			}
+			setRotated(item.Secret, item.Key, item.Output)
 		}
 	}
 
-	s.SetVaultTransitKey(transitKey)
+	if dryRun {
+		return r
+	}
 
-	for k, l := range secrets {
+	// The values themselves remain the same, so does their metadata
+	for k, l := range rotated {
 		for m, n := range l {
-			s.WriteSecretKey(k, m, v.Cipher(n))
+			value := t.Secrets[k][m]
+			value.Value = n
+			t.Secrets[k][m] = value
 		}
 	}
-	fmt.Printf("Rotated secrets from '%v' to '%v'\n", key, transitKey)
+
+	s.save()
+	if err = journal.remove(); err != nil {
+		log.Warnf("Unable to remove the rotation journal at %v: %v", path, err)
+	}
+	return r
 }
 
-// save : write the statefile onto the disk
+// save : write the statefile onto the disk, its content is sorted in order to keep the
+// changes as small as possible
 func (s *State) save() {
 	log.Debugf("Saving state file at %v", s.Config.Path)
-	var output bytes.Buffer
-	y := yaml.NewEncoder(&output)
-	y.SetIndent(2)
-	if err := y.Encode(&s); err != nil {
-		log.Fatalf("Error: %v", err)
-	}
+	s.Version = stateVersion
 
 	filename, err := filepath.Abs(s.Config.Path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err = ioutil.WriteFile(filename, output.Bytes(), 0o600); err != nil {
+	if err = lockState(filename, lockExclusive); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if s.layout() == layoutDirectory {
+		if err = s.saveDirectory(filename); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	data, err := encodeYAML(s)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if err = writeFileAtomic(filename, data, 0o600); err != nil {
 		log.Fatal(err)
 	}
 }
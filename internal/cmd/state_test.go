@@ -49,6 +49,7 @@ func TestStateInit(t *testing.T) {
 
 func TestStateSetVaultTransitKey(t *testing.T) {
 	s := getTestStateClient()
+	s.Init()
 	s.SetVaultTransitKey("foo")
 	s.Load()
 	assert.Equal(t, "foo", s.VaultTransitKey())
@@ -56,12 +57,13 @@ func TestStateSetVaultTransitKey(t *testing.T) {
 
 func TestStateVaultTransitKey(t *testing.T) {
 	s := getTestStateClient()
-	s.Vault.TransitKey = "foo"
+	s.Default.Vault.TransitKey = "foo"
 	assert.Equal(t, "foo", s.VaultTransitKey())
 }
 
 func TestStateSetVaultKVPath(t *testing.T) {
 	s := getTestStateClient()
+	s.Init()
 	s.SetVaultKVPath("secret/foo/")
 	s.Load()
 	assert.Equal(t, "secret/foo/", s.VaultKVPath())
@@ -69,12 +71,13 @@ func TestStateSetVaultKVPath(t *testing.T) {
 
 func TestStateVaultKVPath(t *testing.T) {
 	s := getTestStateClient()
-	s.Vault.KV.Path = "secret/foo/"
+	s.Default.Vault.KV.Path = "secret/foo/"
 	assert.Equal(t, "secret/foo/", s.VaultKVPath())
 }
 
 func TestStateLoad(t *testing.T) {
 	s := getTestStateClient()
+	s.Init()
 	s.SetVaultTransitKey("foo")
 	s.SetVaultKVPath("secret/foo/")
 	assert.Equal(t, "foo", s.VaultTransitKey())
@@ -84,10 +87,190 @@ func TestStateLoad(t *testing.T) {
 func TestStateWriteSecretKey(t *testing.T) {
 	s := getTestStateClient()
 	s.Init()
-	s.WriteSecretKey("foo", "bar", "sensitive")
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive"})
+	s.Load()
+
+	require.Contains(t, s.Default.Secrets, "foo")
+	require.Contains(t, s.Default.Secrets["foo"], "bar")
+	assert.Equal(t, "sensitive", s.Default.Secrets["foo"]["bar"].Value)
+}
+
+func TestStateWriteSecretKeyMetadata(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	s.WriteSecretKey("foo", "bar", SecretKey{
+		Value: "generated",
+		SecretKeyMetadata: SecretKeyMetadata{
+			Description: "database password",
+			Owner:       "dba",
+			Generator:   "random:32",
+		},
+	})
+	s.Load()
+
+	created := s.Default.Secrets["foo"]["bar"]
+	require.NotNil(t, created.CreatedAt)
+	assert.Equal(t, created.CreatedAt, created.UpdatedAt)
+	assert.Equal(t, "random:32", created.Generator)
+
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive", SecretKeyMetadata: SecretKeyMetadata{Owner: "ops"}})
+	s.Load()
+
+	updated := s.Default.Secrets["foo"]["bar"]
+	assert.Equal(t, "sensitive", updated.Value)
+	assert.Equal(t, "database password", updated.Description)
+	assert.Equal(t, "ops", updated.Owner)
+	assert.Empty(t, updated.Generator)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.False(t, updated.UpdatedAt.Before(*created.UpdatedAt))
+}
+
+func TestStateSetSecret(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive"})
+	s.SetSecret("foo", map[string]SecretKey{"baz": {Value: "other"}})
+	s.Load()
+
+	require.Contains(t, s.Default.Secrets, "foo")
+	assert.Equal(t, []string{"baz"}, sortedKeys(s.Default.Secrets["foo"]))
+	assert.Equal(t, "other", s.Default.Secrets["foo"]["baz"].Value)
+}
+
+func TestStateSetSecrets(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive"})
+	s.SetSecrets(map[string]map[string]SecretKey{
+		"foo": {"baz": {Value: "other"}},
+		"qux": {"quux": {Value: "new"}},
+	})
+	s.Load()
+
+	assert.Equal(t, []string{"foo", "qux"}, sortedKeys(s.Default.Secrets))
+	assert.Equal(t, []string{"baz"}, sortedKeys(s.Default.Secrets["foo"]))
+	assert.Equal(t, "new", s.Default.Secrets["qux"]["quux"].Value)
+}
+
+func TestStateVaultKVDeleteMode(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	assert.Equal(t, deleteModeSoft, s.VaultKVDeleteMode())
+	s.SetVaultKVDeleteMode(deleteModeDestroy)
 	s.Load()
+	assert.Equal(t, deleteModeDestroy, s.VaultKVDeleteMode())
+}
+
+func TestStateVaultKVOwnership(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	assert.Equal(t, ownershipAll, s.VaultKVOwnership())
+	s.SetVaultKVOwnership(ownershipMarked)
+	s.Load()
+	assert.Equal(t, ownershipMarked, s.VaultKVOwnership())
+}
+
+func TestStateVaultKVIgnore(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	assert.NoError(t, s.AddVaultKVIgnore("team-*"))
+	assert.NoError(t, s.AddVaultKVIgnore("shared"))
+	assert.NoError(t, s.AddVaultKVIgnore("shared"))
+	assert.Error(t, s.AddVaultKVIgnore("[invalid"))
+	s.Load()
+	assert.Equal(t, []string{"shared", "team-*"}, s.VaultKVIgnore())
+	assert.True(t, s.isIgnored("team-payments"))
+	assert.True(t, s.isIgnored("shared"))
+	assert.False(t, s.isIgnored("mine"))
+
+	assert.NoError(t, s.RemoveVaultKVIgnore("shared"))
+	assert.Error(t, s.RemoveVaultKVIgnore("shared"))
+	assert.False(t, s.isIgnored("shared"))
+}
+
+func TestStateVaultKVMerge(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	assert.NoError(t, s.AddVaultKVMerge("app-*"))
+	s.Load()
+	assert.Equal(t, []string{"app-*"}, s.VaultKVMerge())
+	assert.True(t, s.isMerged("app-foo"))
+	assert.False(t, s.isMerged("foo"))
+
+	assert.NoError(t, s.RemoveVaultKVMerge("app-*"))
+	assert.False(t, s.isMerged("app-foo"))
+}
+
+func TestMatchPattern(t *testing.T) {
+	assert.True(t, matchPattern("foo", "foo"))
+	assert.True(t, matchPattern("app/*", "app/db"))
+	assert.False(t, matchPattern("app/*", "app/env/db"))
+	assert.True(t, matchPattern("app/**", "app/env/db"))
+	assert.True(t, matchPattern("**/db", "app/env/db"))
+	assert.False(t, matchPattern("**/db", "app/env/cache"))
+	assert.True(t, matchPattern("app/**/d[a-c]", "app/env/db"))
+	assert.True(t, matchPattern("**", "anything/at/all"))
+	assert.False(t, matchPattern("app.**", "appxfoo"))
+}
+
+func TestStateTargets(t *testing.T) {
+	s := getTestStateClient()
+	s.Init()
+	s.WriteSecretKey("foo", "bar", SecretKey{Value: "sensitive"})
+
+	require.NoError(t, s.AddTarget("team"))
+	assert.Error(t, s.AddTarget("team"))
+	assert.Error(t, s.AddTarget(defaultTarget))
+
+	s.SelectTarget("team")
+	s.SetVaultKVPath("kv-team/")
+	s.SetVaultTransitKey("team")
+	s.WriteSecretKey("baz", "qux", SecretKey{Value: "other"})
+
+	s = getStateClient(s.Config)
+	s.Load()
+	assert.Equal(t, []string{defaultTarget, "team"}, s.TargetNames())
+	assert.Equal(t, []string{defaultTarget, "team"}, s.selectedTargetNames())
+	assert.Equal(t, "secret/", s.VaultKVPath())
+	assert.Equal(t, "sensitive", s.Default.Secrets["foo"]["bar"].Value)
+
+	s.SelectTarget("team")
+	assert.Equal(t, []string{"team"}, s.selectedTargetNames())
+	assert.Equal(t, "kv-team/", s.VaultKVPath())
+	assert.Equal(t, "team", s.VaultTransitKey())
+	assert.Equal(t, []string{"baz"}, sortedKeys(s.target().Secrets))
+	assert.Equal(t, "other", s.target().Secrets["baz"]["qux"].Value)
+
+	require.NoError(t, s.RemoveTarget("team"))
+	assert.Error(t, s.RemoveTarget("team"))
+	assert.Equal(t, []string{defaultTarget}, s.TargetNames())
+}
+
+func TestStateVaultTransitKeyFor(t *testing.T) {
+	s := getTestStateClient()
+	s.Default.Vault.TransitKey = "default"
+	s.Default.Vault.TransitKeys = []TransitKeyRule{
+		{Pattern: "prod/db:password", Key: "dba-password"},
+		{Pattern: "prod/db", Key: "dba"},
+		{Pattern: "**:*_token", Key: "tokens"},
+	}
+
+	assert.Equal(t, "dba-password", s.VaultTransitKeyFor("prod/db", "password"))
+	assert.Equal(t, "dba", s.VaultTransitKeyFor("prod/db", "user"))
+	assert.Equal(t, "tokens", s.VaultTransitKeyFor("app/api", "github_token"))
+	assert.Equal(t, "default", s.VaultTransitKeyFor("app/api", "url"))
+}
+
+func TestTargetValidate(t *testing.T) {
+	target := &Target{}
+	assert.EqualError(t, target.validate("team"), "target 'team' does not define its KV path, it has to be set in the state file (vault.kv.path)")
+
+	target.Vault.KV.Path = "secret/"
+	assert.EqualError(t, target.validate("team"), "target 'team' does not define a valid KV version (got 0), it has to be set to 1 or 2 in the state file (vault.kv.version)")
+
+	target.Vault.KV.Version = 3
+	assert.Error(t, target.validate("team"))
 
-	require.Contains(t, s.Secrets, "foo")
-	require.Contains(t, s.Secrets["foo"], "bar")
-	assert.Equal(t, "sensitive", s.Secrets["foo"]["bar"])
+	target.Vault.KV.Version = 1
+	assert.NoError(t, target.validate("team"))
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// TargetListResult : Targets defined in the statefile
+type TargetListResult struct {
+	Targets []TargetInfo `json:"targets" yaml:"targets"`
+}
+
+// TargetInfo : Configuration of a target
+type TargetInfo struct {
+	Name         string `json:"name" yaml:"name"`
+	TransitKey   string `json:"transit_key" yaml:"transit_key"`
+	KVPath       string `json:"kv_path" yaml:"kv_path"`
+	KVVersion    int    `json:"kv_version" yaml:"kv_version"`
+	SecretsCount int    `json:"secrets_count" yaml:"secrets_count"`
+}
+
+func (r *TargetListResult) renderTable(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Target", "Transit Key", "KV Path", "KV Version", "Secrets #"})
+	for _, t := range r.Targets {
+		table.Append([]string{t.Name, t.TransitKey, t.KVPath, strconv.Itoa(t.KVVersion), strconv.Itoa(t.SecretsCount)})
+	}
+	table.Render()
+}
+
+// TargetList ..
+func TargetList(_ *cli.Context) (int, error) {
+	s.Load()
+
+	r := &TargetListResult{Targets: []TargetInfo{}}
+	defer s.SelectTarget(s.selected)
+	for _, target := range s.TargetNames() {
+		s.SelectTarget(target)
+		r.Targets = append(r.Targets, TargetInfo{
+			Name:         target,
+			TransitKey:   s.VaultTransitKey(),
+			KVPath:       s.VaultKVPath(),
+			KVVersion:    s.VaultKVVersion(),
+			SecretsCount: len(s.target().Secrets),
+		})
+	}
+
+	if err := render(r); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// TargetAdd ..
+func TargetAdd(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.AddTarget(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+	log.Infof("Target '%v' added, use 'strongbox --target %v kv set-path <path>' to configure it", ctx.Args().First(), ctx.Args().First())
+
+	return 0, nil
+}
+
+// TargetRemove ..
+func TargetRemove(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	if err := s.RemoveTarget(ctx.Args().First()); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
@@ -1,6 +1,11 @@
 package cmd
 
-import cli "github.com/urfave/cli/v2"
+import (
+	"fmt"
+	"io"
+
+	cli "github.com/urfave/cli/v2"
+)
 
 // TransitUse ..
 func TransitUse(ctx *cli.Context) (int, error) {
@@ -10,27 +15,133 @@ func TransitUse(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
+	s.LoadForUpdate()
 	s.SetVaultTransitKey(ctx.Args().First())
 
 	return 0, nil
 }
 
+// TransitAssignmentsResult : TransitKeys assigned to some secrets or secret keys
+type TransitAssignmentsResult struct {
+	Default     string           `json:"default" yaml:"default"`
+	Assignments []TransitKeyRule `json:"assignments" yaml:"assignments"`
+}
+
+func (r *TransitAssignmentsResult) renderTable(w io.Writer) {
+	for _, rule := range r.Assignments {
+		fmt.Fprintf(w, "%v: %v\n", rule.Pattern, rule.Key)
+	}
+	fmt.Fprintf(w, "*: %v\n", r.Default)
+}
+
+// TransitAssignResult : Outcome of the assignment of a TransitKey to a pattern, the
+// Key is empty when the pattern has been unassigned
+type TransitAssignResult struct {
+	Pattern    string `json:"pattern" yaml:"pattern"`
+	Key        string `json:"key,omitempty" yaml:"key,omitempty"`
+	Reciphered int    `json:"reciphered" yaml:"reciphered"`
+}
+
+func (r TransitAssignResult) renderTable(w io.Writer) {
+	if r.Key == "" {
+		fmt.Fprintf(w, "Unassigned '%v', %v value(s) ciphered again\n", r.Pattern, r.Reciphered)
+		return
+	}
+	fmt.Fprintf(w, "Assigned '%v' to '%v', %v value(s) ciphered again\n", r.Key, r.Pattern, r.Reciphered)
+}
+
+// TransitAssign ..
+func TransitAssign(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 2 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	count, err := s.AssignVaultTransitKey(ctx.Args().Get(0), ctx.Args().Get(1))
+	if err != nil {
+		return 1, err
+	}
+
+	if err = render(TransitAssignResult{Pattern: ctx.Args().Get(0), Key: ctx.Args().Get(1), Reciphered: count}); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// TransitUnassign ..
+func TransitUnassign(ctx *cli.Context) (int, error) {
+	if ctx.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.LoadForUpdate()
+
+	count, err := s.UnassignVaultTransitKey(ctx.Args().First())
+	if err != nil {
+		return 1, err
+	}
+
+	if err = render(TransitAssignResult{Pattern: ctx.Args().First(), Reciphered: count}); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// TransitAssignments ..
+func TransitAssignments(_ *cli.Context) (int, error) {
+	s.Load()
+	if err := render(&TransitAssignmentsResult{
+		Default:     s.VaultTransitKey(),
+		Assignments: append([]TransitKeyRule{}, s.VaultTransitKeys()...),
+	}); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
 // TransitInfo ..
 func TransitInfo(_ *cli.Context) (int, error) {
 	s.Load()
-	v.GetTransitInfo()
+	r, err := v.GetTransitInfo()
+	if err != nil {
+		return 1, err
+	}
 
+	if err = render(r); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
 // TransitList ..
 func TransitList(_ *cli.Context) (int, error) {
-	v.ListTransitKeys()
+	r, err := v.ListTransitKeys()
+	if err != nil {
+		return 1, err
+	}
 
+	if err = render(r); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
+// TransitKeyResult : Transit key created or deleted in Vault
+type TransitKeyResult struct {
+	Key    string `json:"key" yaml:"key"`
+	Action string `json:"action" yaml:"action"`
+}
+
+func (r TransitKeyResult) renderTable(w io.Writer) {
+	fmt.Fprintf(w, "Transit key '%v' %v successfully\n", r.Key, r.Action)
+}
+
 // TransitCreate ..
 func TransitCreate(ctx *cli.Context) (int, error) {
 	if ctx.NArg() != 1 {
@@ -39,10 +150,51 @@ func TransitCreate(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	s.Load()
-	v.CreateTransitKey(ctx.Args().First())
+	s.LoadForUpdate()
+	if err := v.CreateTransitKey(ctx.Args().First()); err != nil {
+		return 1, err
+	}
 	s.SetVaultTransitKey(ctx.Args().First())
 
+	if err := render(TransitKeyResult{Key: ctx.Args().First(), Action: "created"}); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// TransitRotateResult : New version of a rotated transit key
+type TransitRotateResult struct {
+	Key     string `json:"key" yaml:"key"`
+	Version int    `json:"version" yaml:"version"`
+}
+
+func (r TransitRotateResult) renderTable(w io.Writer) {
+	fmt.Fprintf(w, "Transit key '%v' rotated to version %v, use 'strongbox secret rewrap' to rewrap the values using it\n", r.Key, r.Version)
+}
+
+// TransitRotate ..
+func TransitRotate(ctx *cli.Context) (int, error) {
+	if ctx.NArg() > 1 {
+		if err := cli.ShowSubcommandHelp(ctx); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	s.Load()
+
+	key := ctx.Args().First()
+	if key == "" {
+		key = s.VaultTransitKey()
+	}
+
+	version, err := v.RotateTransitKey(key)
+	if err != nil {
+		return 1, err
+	}
+
+	if err = render(TransitRotateResult{Key: key, Version: version}); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
 
@@ -54,7 +206,12 @@ func TransitDelete(ctx *cli.Context) (int, error) {
 		}
 		return 1, nil
 	}
-	v.DeleteTransitKey(ctx.Args().First())
+	if err := v.DeleteTransitKey(ctx.Args().First()); err != nil {
+		return 1, err
+	}
 
+	if err := render(TransitKeyResult{Key: ctx.Args().First(), Action: "deleted"}); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"reflect"
+	"sort"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/helper/mlock"
@@ -15,7 +17,7 @@ var (
 	v     *Vault
 )
 
-func configure(ctx *cli.Context) (err error) {
+func configure(ctx *cli.Context, withVault bool) (err error) {
 	start = ctx.App.Metadata["startTime"].(time.Time)
 
 	if err = logger.Configure(logger.Config{
@@ -25,28 +27,48 @@ func configure(ctx *cli.Context) (err error) {
 		return
 	}
 
-	if v, err = getVaultClient(&VaultConfig{
-		Address:  ctx.String("vault-addr"),
-		Token:    ctx.String("vault-token"),
-		RoleID:   ctx.String("vault-role-id"),
-		SecretID: ctx.String("vault-secret-id"),
-	}); err != nil {
+	if err = setOutputFormat(ctx.String("output")); err != nil {
+		return
+	}
+
+	if err = setTransitBatchSize(ctx.Int("transit-batch-size")); err != nil {
+		return
+	}
+
+	if err = setParallelism(ctx.Int("parallelism")); err != nil {
 		return
 	}
 
+	if withVault {
+		if v, err = getVaultClient(&VaultConfig{
+			Address:  ctx.String("vault-addr"),
+			Token:    ctx.String("vault-token"),
+			RoleID:   ctx.String("vault-role-id"),
+			SecretID: ctx.String("vault-secret-id"),
+		}); err != nil {
+			return
+		}
+	}
+
+	// Release the locks of the statefiles when exiting through log.Fatal
+	log.RegisterExitHandler(unlockStates)
+
 	s = getStateClient(&StateConfig{
 		Path: ctx.String("state"),
 	})
+	s.SelectTarget(ctx.String("target"))
 
 	return
 }
 
 func exit(exitCode int, err error) cli.ExitCoder {
-	defer log.WithFields(
-		log.Fields{
-			"execution-time": time.Since(start),
-		},
-	).Debug("exited..")
+	defer func() {
+		log.WithFields(
+			log.Fields{
+				"execution-time": time.Since(start),
+			},
+		).Debug("exited..")
+	}()
 
 	if err != nil {
 		log.Error(err.Error())
@@ -61,6 +83,34 @@ func ExecWrapper(f func(ctx *cli.Context) (int, error)) cli.ActionFunc {
 		if err := mlock.LockMemory(); err != nil {
 			log.WithError(err).Warn("s5 requires the IPC_LOCK capability in order to secure its memory")
 		}
+
+		if err := configure(ctx, true); err != nil {
+			return exit(1, err)
+		}
+
+		defer unlockStates()
+		return exit(f(ctx))
+	}
+}
+
+// LocalExecWrapper gracefully logs and exits our `run` functions which do not need
+// to reach Vault
+func LocalExecWrapper(f func(ctx *cli.Context) (int, error)) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if err := configure(ctx, false); err != nil {
+			return exit(1, err)
+		}
+
+		defer unlockStates()
 		return exit(f(ctx))
 	}
 }
+
+// sortedKeys : Returns the keys of a map indexed by strings, in alphabetical order
+func sortedKeys(m interface{}) (keys []string) {
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Types of the values stored into the statefile
+const (
+	// valueTypeString : the value is a plain string
+	valueTypeString = "string"
+
+	// valueTypeJSON : the value is any other JSON value (number, boolean, null, list or
+	// object), its plaintext is its JSON representation
+	valueTypeJSON = "json"
+)
+
+// SecretKey : Ciphered value of a key, as it is stored into the statefile
+type SecretKey struct {
+	Value string `json:"value" yaml:"value"`
+
+	// Type of the value, empty for strings
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	SecretKeyMetadata `yaml:",inline"`
+}
+
+// SecretKeyMetadata : Optional information about a key, it is never written into Vault
+type SecretKeyMetadata struct {
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Owner       string     `json:"owner,omitempty" yaml:"owner,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" yaml:"createdat,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty" yaml:"updatedat,omitempty"`
+
+	// Generator used to produce the value, if it has been generated (eg: random:32)
+	Generator string `json:"generator,omitempty" yaml:"generator,omitempty"`
+}
+
+// secretKeyFields avoids infinite recursions when (un)marshalling SecretKey
+type secretKeyFields SecretKey
+
+// MarshalYAML : Strings without metadata are stored as plain ciphertexts, other values
+// alongside their type and metadata
+func (k SecretKey) MarshalYAML() (interface{}, error) {
+	if k.isPlain() {
+		return k.Value, nil
+	}
+	return secretKeyFields(k), nil
+}
+
+// UnmarshalYAML : Supports both plain ciphertexts and typed values
+func (k *SecretKey) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*k = SecretKey{}
+		return node.Decode(&k.Value)
+	}
+	return node.Decode((*secretKeyFields)(k))
+}
+
+// MarshalJSON : Strings without metadata are rendered as plain ciphertexts, other values
+// alongside their type and metadata
+func (k SecretKey) MarshalJSON() ([]byte, error) {
+	if k.isPlain() {
+		return json.Marshal(k.Value)
+	}
+	return json.Marshal(secretKeyFields(k))
+}
+
+// isPlain : Returns true if the key holds a string without any metadata
+func (k SecretKey) isPlain() bool {
+	return k.valueType() == valueTypeString && k.SecretKeyMetadata == SecretKeyMetadata{}
+}
+
+// valueType : Returns the type of the value
+func (k SecretKey) valueType() string {
+	if k.Type == "" {
+		return valueTypeString
+	}
+	return k.Type
+}
+
+// encodeValue : Returns the plaintext representation of a value alongside its type
+func encodeValue(value interface{}) (plaintext, valueType string, err error) {
+	if str, ok := value.(string); ok {
+		return str, valueTypeString, nil
+	}
+
+	if err = checkValue(value); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	return string(data), valueTypeJSON, err
+}
+
+// decodeValue : Returns the value represented by a plaintext of a given type
+func decodeValue(plaintext, valueType string) (interface{}, error) {
+	switch valueType {
+	case "", valueTypeString:
+		return plaintext, nil
+	case valueTypeJSON:
+		d := json.NewDecoder(bytes.NewBufferString(plaintext))
+		// Numbers are kept as json.Number, the same way the Vault client decodes them
+		d.UseNumber()
+
+		var value interface{}
+		if err := d.Decode(&value); err != nil {
+			return nil, fmt.Errorf("invalid JSON value: %v", err)
+		}
+
+		if _, err := d.Token(); err != io.EOF {
+			return nil, fmt.Errorf("invalid JSON value: unexpected data after the value")
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type '%v'", valueType)
+	}
+}
+
+// checkValue : Ensures that a value only contains types which can be represented in JSON
+func checkValue(value interface{}) error {
+	switch val := value.(type) {
+	case nil, string, bool, json.Number, float64:
+		return nil
+	case []interface{}:
+		for _, item := range val {
+			if err := checkValue(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, item := range val {
+			if err := checkValue(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// cipherValues : Ciphers the values of some secret keys using the TransitKeys assigned to
+// them, in batches, keeping track of their types
+func cipherValues(values map[string]map[string]interface{}) (map[string]map[string]SecretKey, error) {
+	var items []*transitItem
+	types := make(map[*transitItem]string)
+	for _, secret := range sortedKeys(values) {
+		for _, key := range sortedKeys(values[secret]) {
+			plaintext, valueType, err := encodeValue(values[secret][key])
+			if err != nil {
+				return nil, fmt.Errorf("unable to cipher %v:%v: %v", secret, key, err)
+			}
+
+			item := &transitItem{TransitKey: s.VaultTransitKeyFor(secret, key), Secret: secret, Key: key, Input: plaintext}
+			items = append(items, item)
+			types[item] = valueType
+		}
+	}
+	v.transitBatch(transitEncrypt, items)
+
+	keys := make(map[string]map[string]SecretKey)
+	for _, item := range items {
+		if item.Err != nil {
+			return nil, fmt.Errorf("unable to cipher %v:%v: %v", item.Secret, item.Key, item.Err)
+		}
+
+		k := SecretKey{Value: item.Output}
+		if types[item] != valueTypeString {
+			k.Type = types[item]
+		}
+
+		if keys[item.Secret] == nil {
+			keys[item.Secret] = make(map[string]SecretKey)
+		}
+		keys[item.Secret][item.Key] = k
+	}
+	return keys, nil
+}
+
+// decipherValues : Deciphers the values of some secret keys stored into the statefile, in
+// batches, and decodes them according to their types. The errors are reported for each
+// of the secret keys which could not be deciphered or decoded
+func decipherValues(keys map[string]map[string]SecretKey) (values map[string]map[string]interface{}, errs map[string]map[string]error) {
+	var items []*transitItem
+	for _, secret := range sortedKeys(keys) {
+		for _, key := range sortedKeys(keys[secret]) {
+			items = append(items, &transitItem{TransitKey: s.VaultTransitKeyFor(secret, key), Secret: secret, Key: key, Input: keys[secret][key].Value})
+		}
+	}
+	v.transitBatch(transitDecrypt, items)
+
+	values = make(map[string]map[string]interface{})
+	errs = make(map[string]map[string]error)
+	for _, item := range items {
+		value, err := item.Output, item.Err
+		var decoded interface{}
+		if err == nil {
+			decoded, err = decodeValue(value, keys[item.Secret][item.Key].valueType())
+		}
+
+		if err != nil {
+			if errs[item.Secret] == nil {
+				errs[item.Secret] = make(map[string]error)
+			}
+			errs[item.Secret][item.Key] = err
+			continue
+		}
+
+		if values[item.Secret] == nil {
+			values[item.Secret] = make(map[string]interface{})
+		}
+		values[item.Secret][item.Key] = decoded
+	}
+	return
+}
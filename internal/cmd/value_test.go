@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEncodeDecodeValue(t *testing.T) {
+	for _, value := range []interface{}{
+		"foo",
+		json.Number("42"),
+		true,
+		nil,
+		[]interface{}{"a", json.Number("1.5")},
+		map[string]interface{}{"a": map[string]interface{}{"b": false}},
+	} {
+		plaintext, valueType, err := encodeValue(value)
+		require.NoError(t, err)
+
+		decoded, err := decodeValue(plaintext, valueType)
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	plaintext, valueType, err := encodeValue(json.Number("42"))
+	require.NoError(t, err)
+	assert.Equal(t, "42", plaintext)
+	assert.Equal(t, valueTypeJSON, valueType)
+
+	_, _, err = encodeValue(struct{}{})
+	assert.Error(t, err)
+
+	_, err = decodeValue("{", valueTypeJSON)
+	assert.Error(t, err)
+
+	_, err = decodeValue("1 2", valueTypeJSON)
+	assert.Error(t, err)
+
+	_, err = decodeValue("1", "xml")
+	assert.Error(t, err)
+}
+
+func TestSecretKeyYAML(t *testing.T) {
+	keys := map[string]SecretKey{
+		"password": {Value: "{{s5:Zm9v}}"},
+		"port":     {Value: "{{s5:YmFy}}", Type: valueTypeJSON},
+	}
+
+	data, err := yaml.Marshal(keys)
+	require.NoError(t, err)
+	assert.Equal(t, "password: '{{s5:Zm9v}}'\nport:\n    value: '{{s5:YmFy}}'\n    type: json\n", string(data))
+
+	loaded := map[string]SecretKey{}
+	require.NoError(t, yaml.Unmarshal(data, &loaded))
+	assert.Equal(t, keys, loaded)
+}
+
+func TestSecretKeyMetadataYAML(t *testing.T) {
+	created := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	key := SecretKey{
+		Value: "{{s5:Zm9v}}",
+		SecretKeyMetadata: SecretKeyMetadata{
+			Description: "api token",
+			CreatedAt:   &created,
+		},
+	}
+
+	data, err := yaml.Marshal(key)
+	require.NoError(t, err)
+	assert.Equal(t, "value: '{{s5:Zm9v}}'\ndescription: api token\ncreatedat: 2022-03-01T10:00:00Z\n", string(data))
+
+	var loaded SecretKey
+	require.NoError(t, yaml.Unmarshal(data, &loaded))
+	assert.Equal(t, key, loaded)
+
+	data, err = json.Marshal(key)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"value": "{{s5:Zm9v}}", "description": "api token", "created_at": "2022-03-01T10:00:00Z"}`, string(data))
+}
+
+func TestComputeDiffTypedValues(t *testing.T) {
+	d := computeDiff(
+		map[string]map[string]interface{}{"foo": {"a": json.Number("5"), "b": []interface{}{"x"}}},
+		map[string]map[string]interface{}{"foo": {"a": "5", "b": []interface{}{"x"}}},
+	)
+	assert.Equal(t, map[string][]string{"foo": {"a"}}, d.UpdateKeys)
+}
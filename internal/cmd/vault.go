@@ -1,19 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"os"
+	"net/http"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/fatih/color"
 	"github.com/hashicorp/vault/api"
 	"github.com/mitchellh/go-homedir"
 	"github.com/olekukonko/tablewriter"
 	log "github.com/sirupsen/logrus"
 
 	s5 "github.com/mvisonneau/s5/cipher"
-	s5Vault "github.com/mvisonneau/s5/cipher/vault"
 )
 
 // Vault : Handles a Vault API Client
@@ -71,186 +76,549 @@ func getVaultClient(vc *VaultConfig) (*Vault, error) {
 	return &Vault{v}, nil
 }
 
+// TransitInfoResult : Information about a transit key, as returned by Vault
+type TransitInfoResult map[string]interface{}
+
 // GetTransitInfo : Fetch some information from Vault about the configured TransitKey
-func (v *Vault) GetTransitInfo() {
-	d, err := v.Client.Logical().Read("transit/keys/" + s.Vault.TransitKey)
+func (v *Vault) GetTransitInfo() (TransitInfoResult, error) {
+	d, err := v.Client.Logical().Read("transit/keys/" + s.VaultTransitKey())
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return nil, fmt.Errorf("Vault error: %v", err)
 	}
 
 	if d == nil {
-		log.Fatalf("The configured transit key doesn't seem to exists : %v", s.Vault.TransitKey)
+		return nil, fmt.Errorf("The configured transit key doesn't seem to exists : %v", s.VaultTransitKey())
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	return TransitInfoResult(d.Data), nil
+}
+
+func (r TransitInfoResult) renderTable(w io.Writer) {
+	table := tablewriter.NewWriter(w)
 	table.SetHeader([]string{"Key", "Value"})
-	for k, l := range d.Data {
-		table.Append([]string{k, fmt.Sprintf("%v", l)})
+	for _, k := range sortedKeys(r) {
+		table.Append([]string{k, fmt.Sprintf("%v", r[k])})
 	}
 	table.Render()
 }
 
 // CreateTransitKey : Create a new transit key in Vault
-func (v *Vault) CreateTransitKey(key string) {
-	_, err := v.Client.Logical().Write("transit/keys/"+key, make(map[string]interface{}))
+func (v *Vault) CreateTransitKey(key string) error {
+	if _, err := v.Client.Logical().Write("transit/keys/"+key, make(map[string]interface{})); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
+	}
+	return nil
+}
+
+// RotateTransitKey : Creates a new version of a transit key, returns its number
+func (v *Vault) RotateTransitKey(key string) (int, error) {
+	if _, err := v.Client.Logical().Write("transit/keys/"+key+"/rotate", map[string]interface{}{}); err != nil {
+		return 0, fmt.Errorf("Vault error: %v", err)
+	}
+	return v.transitKeyLatestVersion(key)
+}
+
+// transitKeyLatestVersion : Returns the latest version of a transit key, the keys which
+// cannot be read using the current token are reported as inaccessible
+func (v *Vault) transitKeyLatestVersion(key string) (int, error) {
+	d, err := v.Client.Logical().Read("transit/keys/" + key)
+	if err != nil {
+		var re *api.ResponseError
+		if errors.As(err, &re) && re.StatusCode == http.StatusForbidden {
+			return 0, &inaccessibleError{transitKey: key, err: err}
+		}
+		return 0, fmt.Errorf("Vault error: %v", err)
+	}
+
+	if d == nil {
+		return 0, &inaccessibleError{transitKey: key, err: fmt.Errorf("transit key not found")}
+	}
+
+	version, err := strconv.Atoi(fmt.Sprintf("%v", d.Data["latest_version"]))
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return 0, fmt.Errorf("unable to parse the latest version of transit key '%v': %v", key, err)
 	}
+	return version, nil
+}
+
+// SetTransitKeyMinDecryptionVersion : Prevents the values ciphered using the versions of
+// a transit key older than the given one from being deciphered
+func (v *Vault) SetTransitKeyMinDecryptionVersion(key string, version int) error {
+	if _, err := v.Client.Logical().Write("transit/keys/"+key+"/config", map[string]interface{}{
+		"min_decryption_version": version,
+	}); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
+	}
+	return nil
+}
 
-	fmt.Println("Transit key created successfully")
+// TransitKeysResult : Transit keys available in Vault
+type TransitKeysResult struct {
+	Keys []string `json:"keys" yaml:"keys"`
 }
 
 // ListTransitKeys : List available transit keys from Vault
-func (v *Vault) ListTransitKeys() {
+func (v *Vault) ListTransitKeys() (*TransitKeysResult, error) {
 	d, err := v.Client.Logical().List("transit/keys")
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return nil, fmt.Errorf("Vault error: %v", err)
 	}
 
+	r := &TransitKeysResult{Keys: []string{}}
 	if d != nil {
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Key"})
 		for _, l := range d.Data["keys"].([]interface{}) {
-			table.Append([]string{l.(string)})
+			r.Keys = append(r.Keys, l.(string))
 		}
-		table.Render()
 	}
+
+	return r, nil
+}
+
+func (r *TransitKeysResult) renderTable(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Key"})
+	for _, k := range r.Keys {
+		table.Append([]string{k})
+	}
+	table.Render()
 }
 
 // DeleteTransitKey : Delete a transit key from Vault
-func (v *Vault) DeleteTransitKey(key string) {
+func (v *Vault) DeleteTransitKey(key string) error {
 	p := make(map[string]interface{})
 	p["deletion_allowed"] = "true"
-	_, err := v.Client.Logical().Write("transit/keys/"+key+"/config", p)
-	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+	if _, err := v.Client.Logical().Write("transit/keys/"+key+"/config", p); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
 	}
 
-	_, err = v.Client.Logical().Delete("transit/keys/" + key)
-	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+	if _, err := v.Client.Logical().Delete("transit/keys/" + key); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
 	}
-	color.Green("=> Deleted transit key '%v' from Vault", key)
+	return nil
 }
 
-// ListSecrets : Do what it says
-func (v *Vault) ListSecrets() {
-	log.Debugf("Listing secrets in Vault KV Path: %v", s.VaultKVPath())
-	d, err := v.Client.Logical().List(s.VaultKVPath())
-	if err != nil {
-		log.Fatalf("Vault error: %v", err)
-	}
-
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Key"})
-	for _, l := range d.Data["keys"].([]interface{}) {
-		table.Append([]string{l.(string)})
-	}
-	table.Render()
+// VaultStatus : Information about the Vault API endpoint/cluster
+type VaultStatus struct {
+	Sealed         bool   `json:"sealed" yaml:"sealed"`
+	ClusterVersion string `json:"cluster_version" yaml:"cluster_version"`
+	ClusterID      string `json:"cluster_id" yaml:"cluster_id"`
+	SecretsCount   int    `json:"secrets_count" yaml:"secrets_count"`
 }
 
 // Status : Return information about Vault API endpoint/cluster
-func (v *Vault) Status() {
+func (v *Vault) Status() (*VaultStatus, error) {
 	vh, err := v.Client.Sys().Health()
-	fmt.Println("[VAULT]")
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return nil, fmt.Errorf("Vault error: %v", err)
 	}
 
-	listPath := s.VaultKVPath()
-	if s.VaultKVVersion() == 2 {
-		listPath = s.VaultKVPath() + "metadata"
-	}
-
-	d, err := v.Client.Logical().List(listPath)
+	secrets, err := listRemoteSecrets()
 	if err != nil {
-		log.Fatalf("vault error: %v", err)
+		return nil, fmt.Errorf("vault error: %v", err)
 	}
 
-	secretsCount := 0
-	if d != nil {
-		if keys, ok := d.Data["keys"]; ok {
-			secretsCount = len(keys.([]interface{}))
-		}
-	}
+	return &VaultStatus{
+		Sealed:         vh.Sealed,
+		ClusterVersion: vh.Version,
+		ClusterID:      vh.ClusterID,
+		SecretsCount:   len(secrets),
+	}, nil
+}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.Append([]string{"Sealed", fmt.Sprintf("%v", vh.Sealed)})
-	table.Append([]string{"Cluster Version", vh.Version})
-	table.Append([]string{"Cluster ID", vh.ClusterID})
-	table.Append([]string{"Secrets #", fmt.Sprintf("%v", secretsCount)})
+func (vs *VaultStatus) renderTable(w io.Writer) {
+	fmt.Fprintln(w, "[VAULT]")
+	table := tablewriter.NewWriter(w)
+	table.Append([]string{"Sealed", fmt.Sprintf("%v", vs.Sealed)})
+	table.Append([]string{"Cluster Version", vs.ClusterVersion})
+	table.Append([]string{"Cluster ID", vs.ClusterID})
+	table.Append([]string{"Secrets #", fmt.Sprintf("%v", vs.SecretsCount)})
 	table.Render()
 }
 
-// Cipher : Cipher a value using the TransitKey
-func (v *Vault) Cipher(value string) string {
-	s5Engine := s5Vault.Client{
-		Client: v.Client,
-		Config: &s5Vault.Config{
-			Key: s.Vault.TransitKey,
-		},
-	}
+// Cipher : Cipher the value of a secret key using the TransitKey assigned to it
+func (v *Vault) Cipher(secret, key, value string) string {
+	return v.cipher(s.VaultTransitKeyFor(secret, key), value)
+}
 
-	cipheredValue, err := s5Engine.Cipher(value)
-	if err != nil {
-		log.Fatal(err)
+// Decipher : Decipher the value of a secret key using the TransitKey assigned to it
+func (v *Vault) Decipher(secret, key, value string) (string, error) {
+	return v.decipher(s.VaultTransitKeyFor(secret, key), value)
+}
+
+// cipher : Cipher a value using a TransitKey
+func (v *Vault) cipher(transitKey, value string) string {
+	item := &transitItem{TransitKey: transitKey, Input: value}
+	v.transitBatch(transitEncrypt, []*transitItem{item})
+	if item.Err != nil {
+		log.Fatal(item.Err)
 	}
+	return item.Output
+}
 
-	return s5.GenerateOutput(cipheredValue)
+// s5Ciphertext : Returns the content of the s5 envelope of a Vault ciphertext. Like s5,
+// the prefix of the ciphertexts of the first version of a key is stripped. The envelope
+// cannot hold colons, the ones of the later versions are prefixed with v<version>=
+// instead, which cannot be mistaken for base64. The s5 CLI cannot decipher them
+func s5Ciphertext(ciphertext string) string {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || parts[1] == "v1" {
+		return parts[len(parts)-1]
+	}
+	return parts[1] + "=" + parts[2]
 }
 
-// Decipher : Decipher a value using the TransitKey
-func (v *Vault) Decipher(value string) string {
-	s5Engine := s5Vault.Client{
-		Client: v.Client,
-		Config: &s5Vault.Config{
-			Key: s.Vault.TransitKey,
-		},
+// vaultCiphertext : Returns the Vault ciphertext held by the content of an s5 envelope
+func vaultCiphertext(content string) string {
+	if parts := strings.SplitN(content, "=", 2); len(parts) == 2 && strings.HasPrefix(parts[0], "v") && len(parts[1]) > 0 {
+		if _, err := strconv.Atoi(strings.TrimPrefix(parts[0], "v")); err == nil {
+			return "vault:" + parts[0] + ":" + parts[1]
+		}
 	}
+	return "vault:v1:" + content
+}
 
+// ciphertextVersion : Returns the version of the transit key used to cipher a value
+func ciphertextVersion(value string) (int, error) {
 	parsedInput, err := s5.ParseInput(value)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	decipheredValue, err := s5Engine.Decipher(parsedInput)
-	if err != nil {
-		log.Fatal(err)
+	parts := strings.SplitN(vaultCiphertext(parsedInput), ":", 3)
+	return strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+}
+
+// decipher : Decipher a value using a TransitKey
+func (v *Vault) decipher(transitKey, value string) (string, error) {
+	item := &transitItem{TransitKey: transitKey, Input: value}
+	v.transitBatch(transitDecrypt, []*transitItem{item})
+	return item.Output, item.Err
+}
+
+// inaccessibleError : Returned when a value cannot be deciphered using the current token
+type inaccessibleError struct {
+	transitKey string
+	err        error
+}
+
+func (e *inaccessibleError) Error() string {
+	return fmt.Sprintf("unable to decipher using transit key '%v': %v", e.transitKey, e.err)
+}
+
+// isInaccessible : Returns true if the error is due to a value which cannot be deciphered
+// using the current token
+func isInaccessible(err error) bool {
+	var ie *inaccessibleError
+	return errors.As(err, &ie)
+}
+
+// WriteSecret : Write a secret into Vault, on KV v2 the write only succeeds if the
+// current version of the secret matches the provided one (0 if it does not exist)
+func (v *Vault) WriteSecret(secret string, data map[string]interface{}, version int) error {
+	if err := v.writeSecret(secret, data, version); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
 	}
 
-	return decipheredValue
+	if s.VaultKVOwnership() == ownershipMarked {
+		if err := v.markSecret(secret); err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+	}
+	log.Debugf("Added/Updated secret '%v' and managed keys", secret)
+	return nil
 }
 
-// WriteSecret : Write a secret into Vault
-func (v *Vault) WriteSecret(secret string, data map[string]interface{}) {
+func (v *Vault) writeSecret(secret string, data map[string]interface{}, version int) error {
 	queryPath := s.VaultKVPath() + secret
 	var payload map[string]interface{}
-	if s.Vault.KV.Version == 2 {
+	if s.VaultKVVersion() == 2 {
 		queryPath = s.VaultKVPath() + "data/" + secret
 		payload = make(map[string]interface{})
 		payload["data"] = data
+		payload["options"] = map[string]interface{}{
+			"cas": version,
+		}
 	} else {
 		payload = data
 	}
 
-	_, err := v.Client.Logical().Write(queryPath, payload)
+	if _, err := v.Client.Logical().Write(queryPath, payload); err != nil {
+		return casError(secret, err)
+	}
+	return nil
+}
+
+// Custom metadata used to mark the KV v2 secrets managed by strongbox
+const (
+	managedByKey   = "managed-by"
+	managedByValue = "strongbox"
+)
+
+// markSecret : Marks a KV v2 secret as managed by strongbox using its custom metadata
+func (v *Vault) markSecret(secret string) error {
+	return v.updateCustomMetadata(secret, map[string]string{managedByKey: managedByValue})
+}
+
+// WriteSecretLabels : Writes the labels of a secret into its KV v2 custom metadata
+func (v *Vault) WriteSecretLabels(secret string, labels map[string]string) error {
+	if err := v.updateCustomMetadata(secret, labels); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
+	}
+	log.Debugf("Updated labels of secret '%v'", secret)
+	return nil
+}
+
+// updateCustomMetadata : Sets some custom metadata of a KV v2 secret, the other ones
+// are preserved
+func (v *Vault) updateCustomMetadata(secret string, values map[string]string) error {
+	d, err := v.Client.Logical().Read(s.VaultKVPath() + "metadata/" + secret)
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return err
+	}
+
+	customMetadata := make(map[string]interface{})
+	if d != nil {
+		if cm, ok := d.Data["custom_metadata"].(map[string]interface{}); ok {
+			customMetadata = cm
+		}
+	}
+
+	changed := false
+	for key, value := range values {
+		if customMetadata[key] != value {
+			customMetadata[key] = value
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	_, err = v.Client.Logical().Write(s.VaultKVPath()+"metadata/"+secret, map[string]interface{}{
+		"custom_metadata": customMetadata,
+	})
+	return err
+}
+
+// casError : Returns a more explicit error when a check-and-set write has been refused
+func casError(secret string, err error) error {
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest {
+		for _, e := range respErr.Errors {
+			if strings.Contains(e, "check-and-set") {
+				return fmt.Errorf("secret '%v' has been updated in Vault since it was read, please run 'strongbox plan' again (%v)", secret, e)
+			}
+		}
+	}
+	return err
+}
+
+// KV v2 secrets deletion modes
+const (
+	// deleteModeSoft only deletes the latest version of the secret, it can be undeleted
+	deleteModeSoft = "soft"
+
+	// deleteModeDestroy permanently destroys all the versions of the secret, its metadata are kept
+	deleteModeDestroy = "destroy"
+
+	// deleteModeMetadata removes the metadata and all the versions of the secret
+	deleteModeMetadata = "metadata"
+)
+
+// deleteModeDescriptions : Human readable description of the KV v2 deletion modes
+var deleteModeDescriptions = map[string]string{
+	deleteModeSoft:     "soft delete of the latest version",
+	deleteModeDestroy:  "permanent destruction of all versions",
+	deleteModeMetadata: "removal of all versions and metadata",
+}
+
+// validateDeleteMode : Ensures the value is a supported deletion mode
+func validateDeleteMode(mode string) error {
+	if _, ok := deleteModeDescriptions[mode]; !ok {
+		return fmt.Errorf("delete mode must be one of %v, %v or %v, got '%v'", deleteModeSoft, deleteModeDestroy, deleteModeMetadata, mode)
 	}
-	color.Green("=> Added/Updated secret '%v' and managed keys", secret)
+	return nil
 }
 
 // DeleteSecret : DeleteSecret a secret from Vault
-func (v *Vault) DeleteSecret(secret string) {
-	_, err := v.Client.Logical().Delete(s.VaultKVPath() + secret)
+func (v *Vault) DeleteSecret(secret string) error {
+	if s.VaultKVVersion() != 2 {
+		if _, err := v.Client.Logical().Delete(s.VaultKVPath() + secret); err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+		log.Debugf("Deleted secret '%v' and its underlying keys", secret)
+		return nil
+	}
+
+	switch s.VaultKVDeleteMode() {
+	case deleteModeDestroy:
+		versions, err := v.secretVersions(secret)
+		if err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+
+		if _, err = v.Client.Logical().Write(s.VaultKVPath()+"destroy/"+secret, map[string]interface{}{
+			"versions": versions,
+		}); err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+	case deleteModeMetadata:
+		if _, err := v.Client.Logical().Delete(s.VaultKVPath() + "metadata/" + secret); err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+	default:
+		if _, err := v.Client.Logical().Delete(s.VaultKVPath() + "data/" + secret); err != nil {
+			return fmt.Errorf("Vault error: %v", err)
+		}
+	}
+
+	log.Debugf("Deleted secret '%v' and its underlying keys (%v)", secret, deleteModeDescriptions[s.VaultKVDeleteMode()])
+	return nil
+}
+
+// secretVersions : Returns the list of versions of a KV v2 secret
+func (v *Vault) secretVersions(secret string) ([]int, error) {
+	d, err := v.Client.Logical().Read(s.VaultKVPath() + "metadata/" + secret)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	var versions []int
+	if vs, ok := d.Data["versions"].(map[string]interface{}); ok {
+		for version := range vs {
+			i, err := strconv.Atoi(version)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse secret version from Vault API response: %v", err)
+			}
+			versions = append(versions, i)
+		}
+	}
+	sort.Ints(versions)
+
+	return versions, nil
+}
+
+// DeleteSecretKeys : Delete keys of a secret from Vault, on KV v2 the deletion only
+// succeeds if the current version of the secret matches the provided one
+func (v *Vault) DeleteSecretKeys(secret string, keys []string, version int) error {
+	if s.VaultKVVersion() == 2 {
+		data := make(map[string]interface{})
+		for _, key := range keys {
+			data[key] = nil
+		}
+
+		// The PATCH method is only available from Vault 1.9 onwards and requires the "patch" capability
+		_, err := v.Client.Logical().JSONMergePatch(context.Background(), s.VaultKVPath()+"data/"+secret, map[string]interface{}{
+			"data": data,
+			"options": map[string]interface{}{
+				"cas": version,
+			},
+		})
+
+		if err == nil {
+			log.Debugf("Deleted keys %v of secret '%v'", keys, secret)
+			return nil
+		}
+
+		var respErr *api.ResponseError
+		if !errors.As(err, &respErr) || (respErr.StatusCode != http.StatusMethodNotAllowed &&
+			respErr.StatusCode != http.StatusForbidden &&
+			respErr.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("Vault error: %v", casError(secret, err))
+		}
+
+		log.Debugf("Unable to patch secret '%v', falling back to read-modify-write: %v", secret, err)
+	}
+
+	current, err := v.readSecret(secret)
 	if err != nil {
-		log.Fatalf("Vault error: %v", err)
+		return fmt.Errorf("Vault error: %v", err)
 	}
-	color.Green("=> Deleted secret '%v' and its underlying keys", secret)
+
+	if current == nil || current.Data == nil {
+		log.Debugf("Secret '%v' does not exist, nothing to delete", secret)
+		return nil
+	}
+
+	if s.VaultKVVersion() == 2 && current.Version != version {
+		return fmt.Errorf("Vault error: secret '%v' has been updated in Vault since it was read, please run 'strongbox plan' again", secret)
+	}
+
+	for _, key := range keys {
+		delete(current.Data, key)
+	}
+
+	// On KV v2, the write is made using the version we have just read in order to
+	// ensure that the secret has not been updated in between both operations
+	if err = v.writeSecret(secret, current.Data, current.Version); err != nil {
+		return fmt.Errorf("Vault error: %v", err)
+	}
+
+	log.Debugf("Deleted keys %v of secret '%v'", keys, secret)
+	return nil
 }
 
-// DeleteSecretKey : Delete a key of a secret from Vault
-func (v *Vault) DeleteSecretKey(secret, key string) {
-	// TODO: Implement!
-	color.Yellow("=> [NOT IMPLEMENTED] - Deleted secret:key %v:%v", secret, key)
+// kvSecret : Content of a secret stored in the Vault KV
+type kvSecret struct {
+	// Data is nil when the latest version of a KV v2 secret has been deleted
+	Data map[string]interface{}
+
+	// Version is the current version of the secret, only available on KV v2
+	Version int
+
+	// CustomMetadata of the secret, only available on KV v2
+	CustomMetadata map[string]interface{}
+}
+
+// values : Returns the values of the secret
+func (ks *kvSecret) values() (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for m, n := range ks.Data {
+		if err := checkValue(n); err != nil {
+			return nil, fmt.Errorf("key '%v': %v", m, err)
+		}
+		values[m] = n
+	}
+	return values, nil
+}
+
+// managed : Returns true if the secret has been marked as managed by strongbox
+func (ks *kvSecret) managed() bool {
+	return ks.CustomMetadata[managedByKey] == managedByValue
+}
+
+// readSecret : Read a secret from the Vault KV, returns nil if it does not exist
+func (v *Vault) readSecret(secret string) (*kvSecret, error) {
+	if s.VaultKVVersion() != 2 {
+		d, err := v.Client.Logical().Read(s.VaultKVPath() + secret)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return &kvSecret{Data: d.Data}, nil
+	}
+
+	d, err := v.Client.Logical().Read(s.VaultKVPath() + "data/" + secret)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	if _, ok := d.Data["data"]; !ok {
+		return nil, fmt.Errorf("unable to parse content from Vault API response")
+	}
+
+	ks := &kvSecret{}
+	ks.Data, _ = d.Data["data"].(map[string]interface{})
+	if metadata, ok := d.Data["metadata"].(map[string]interface{}); ok {
+		ks.CustomMetadata, _ = metadata["custom_metadata"].(map[string]interface{})
+		if version, ok := metadata["version"].(json.Number); ok {
+			i, err := version.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse secret version from Vault API response: %v", err)
+			}
+			ks.Version = int(i)
+		}
+	}
+
+	return ks, nil
 }
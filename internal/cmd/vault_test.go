@@ -1,3 +1,205 @@
 package cmd
 
-// TODO: Implement
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS5Ciphertext(t *testing.T) {
+	for ciphertext, content := range map[string]string{
+		"vault:v1:Zm9vYmFy":  "Zm9vYmFy",
+		"vault:v2:Zm9vYg==":  "v2=Zm9vYg==",
+		"vault:v12:Zm9vYmFy": "v12=Zm9vYmFy",
+	} {
+		assert.Equal(t, content, s5Ciphertext(ciphertext))
+		assert.Equal(t, ciphertext, vaultCiphertext(content))
+	}
+
+	// s5 already strips the prefix of the first version
+	assert.Equal(t, "Zm9vYmFy", s5Ciphertext("Zm9vYmFy"))
+	assert.Equal(t, "vault:v1:Zm9vYg==", vaultCiphertext("Zm9vYg=="))
+}
+
+func TestCiphertextVersion(t *testing.T) {
+	version, err := ciphertextVersion("{{s5:Zm9vYmFy}}")
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	version, err = ciphertextVersion("{{s5:v3=Zm9vYmFy}}")
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	_, err = ciphertextVersion("foo")
+	assert.Error(t, err)
+}
+
+func TestVaultDeleteSecretKeys(t *testing.T) {
+	// Servers which can't patch the secret are handled using a read-modify-write
+	for _, status := range []int{0, http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotFound} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			f := newFakeVault(t)
+			f.PatchStatus = status
+			s = getTestStateClient()
+			s.Init()
+			f.KV["secret"]["foo"] = &fakeSecret{Versions: []*fakeSecretVersion{
+				{Data: map[string]interface{}{"bar": "1", "baz": "2", "qux": "3"}},
+			}}
+
+			require.NoError(t, v.DeleteSecretKeys("foo", []string{"bar"}, 1))
+			assert.Equal(t, map[string]interface{}{"baz": "2", "qux": "3"}, f.KV["secret"]["foo"].data())
+			assert.Equal(t, 2, f.KV["secret"]["foo"].current())
+			if status == 0 {
+				assert.Equal(t, []string{"PATCH secret/data/foo"}, f.Requests)
+			} else {
+				assert.Equal(t, []string{"PATCH secret/data/foo", "GET secret/data/foo", "PUT secret/data/foo"}, f.Requests)
+			}
+
+			// The keys are not deleted if the secret has been updated since it was read
+			err := v.DeleteSecretKeys("foo", []string{"baz"}, 1)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "secret 'foo' has been updated in Vault since it was read")
+			assert.Equal(t, map[string]interface{}{"baz": "2", "qux": "3"}, f.KV["secret"]["foo"].data())
+		})
+	}
+
+	// Other errors are not retried
+	f := newFakeVault(t)
+	f.PatchStatus = http.StatusInternalServerError
+	f.KV["secret"]["foo"] = &fakeSecret{Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}}}
+	assert.Error(t, v.DeleteSecretKeys("foo", []string{"bar"}, 1))
+	assert.Equal(t, []string{"PATCH secret/data/foo"}, f.Requests)
+
+	// KV v1 secrets are rewritten without the deleted keys
+	f = newFakeVault(t)
+	f.KV["kv"] = map[string]*fakeSecret{"foo": {Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1", "baz": "2"}}}}}
+	s.SetVaultKVPath("kv/")
+	s.SetVaultKVVersion(1)
+	require.NoError(t, v.DeleteSecretKeys("foo", []string{"bar"}, 0))
+	assert.Equal(t, map[string]interface{}{"baz": "2"}, f.KV["kv"]["foo"].data())
+	assert.Equal(t, []string{"GET kv/foo", "PUT kv/foo"}, f.Requests)
+
+	// Nothing is written when the secret does not exist anymore
+	f.Requests = nil
+	require.NoError(t, v.DeleteSecretKeys("missing", []string{"bar"}, 0))
+	assert.Equal(t, []string{"GET kv/missing"}, f.Requests)
+}
+
+func TestVaultDeleteSecret(t *testing.T) {
+	for mode, expected := range map[string][]string{
+		deleteModeSoft:     {"DELETE secret/data/foo"},
+		deleteModeDestroy:  {"GET secret/metadata/foo", "PUT secret/destroy/foo"},
+		deleteModeMetadata: {"DELETE secret/metadata/foo"},
+	} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeVault(t)
+			s = getTestStateClient()
+			s.Init()
+			s.SetVaultKVDeleteMode(mode)
+			f.KV["secret"]["foo"] = &fakeSecret{
+				Versions: []*fakeSecretVersion{
+					{Data: map[string]interface{}{"bar": "1"}},
+					{Data: map[string]interface{}{"bar": "2"}},
+				},
+				CustomMetadata: map[string]interface{}{"team": "a"},
+			}
+
+			require.NoError(t, v.DeleteSecret("foo"))
+			assert.Equal(t, expected, f.Requests)
+
+			ks, err := v.readSecret("foo")
+			require.NoError(t, err)
+			if mode == deleteModeMetadata {
+				assert.Nil(t, ks)
+				assert.NotContains(t, f.KV["secret"], "foo")
+				return
+			}
+
+			// The version of the deleted secret is still required in order to write it again
+			require.NotNil(t, ks)
+			assert.Nil(t, ks.Data)
+			assert.Equal(t, 2, ks.Version)
+			assert.Equal(t, map[string]interface{}{"team": "a"}, f.KV["secret"]["foo"].CustomMetadata)
+
+			versions := f.KV["secret"]["foo"].Versions
+			if mode == deleteModeSoft {
+				assert.Equal(t, []*fakeSecretVersion{
+					{Data: map[string]interface{}{"bar": "1"}},
+					{Data: map[string]interface{}{"bar": "2"}, Deleted: true},
+				}, versions)
+			} else {
+				assert.True(t, versions[0].Destroyed)
+				assert.True(t, versions[1].Destroyed)
+			}
+		})
+	}
+
+	// KV v1 secrets do not have any deletion mode
+	f := newFakeVault(t)
+	f.KV["kv"] = map[string]*fakeSecret{"foo": {Versions: []*fakeSecretVersion{{Data: map[string]interface{}{"bar": "1"}}}}}
+	s.SetVaultKVPath("kv/")
+	s.SetVaultKVVersion(1)
+	require.NoError(t, v.DeleteSecret("foo"))
+	assert.Equal(t, []string{"DELETE kv/foo"}, f.Requests)
+	assert.Empty(t, f.KV["kv"])
+}
+
+func TestVaultWriteSecretCAS(t *testing.T) {
+	f := newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+
+	require.NoError(t, v.WriteSecret("foo", map[string]interface{}{"bar": "1"}, 0))
+
+	// The secret has been created since it was read
+	err := v.WriteSecret("foo", map[string]interface{}{"bar": "2"}, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "secret 'foo' has been updated in Vault since it was read")
+	assert.Equal(t, 1, f.KV["secret"]["foo"].current())
+
+	require.NoError(t, v.WriteSecret("foo", map[string]interface{}{"bar": "2"}, 1))
+	assert.Equal(t, map[string]interface{}{"bar": "2"}, f.KV["secret"]["foo"].data())
+}
+
+func TestVaultCipherTransitKeyFor(t *testing.T) {
+	f := newFakeVault(t)
+	s = getTestStateClient()
+	s.Init()
+	s.setSecretKey("db", "password", SecretKey{Value: v.Cipher("db", "password", "sensitive")})
+	s.setSecretKey("db", "user", SecretKey{Value: v.Cipher("db", "user", "admin")})
+
+	// Assigning a transit key ciphers the affected values again using it
+	count, err := s.AssignVaultTransitKey("db:password", "dba")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	password := s.target().Secrets["db"]["password"].Value
+	value, err := v.Decipher("db", "password", password)
+	require.NoError(t, err)
+	assert.Equal(t, "sensitive", value)
+
+	_, err = v.decipher("default", password)
+	assert.Error(t, err)
+
+	// The values of the keys which are not assigned keep using the transit key of the target
+	f.Requests = nil
+	keys, err := cipherValues(map[string]map[string]interface{}{"db": {"password": "new", "user": "root"}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"PUT transit/encrypt/dba", "PUT transit/encrypt/default"}, f.Requests)
+
+	value, err = v.decipher("default", keys["db"]["user"].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "root", value)
+
+	// A token which cannot use the assigned transit key can still decipher the other keys
+	f.Forbidden["dba"] = true
+	_, err = v.Decipher("db", "password", keys["db"]["password"].Value)
+	assert.True(t, isInaccessible(err))
+
+	value, err = v.Decipher("db", "user", s.target().Secrets["db"]["user"].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", value)
+}